@@ -0,0 +1,266 @@
+package dfjoin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// gzipTail describes the end of the last member of a gzip file, where new
+// deflate data are appended, see gzappend.c.
+type gzipTail struct {
+	finalOff  int64 // offset of the byte holding the last-block bit of the final block
+	finalMask byte
+	end       int64 // offset right after the deflate data
+	lastByte  byte  // last byte of the deflate data with its unused bits cleared
+	lastBits  uint  // number of bits used in lastByte, 0 means it's fully used
+	crc32Sum  uint32
+	size      uint32
+}
+
+var errAppenderClosed = errors.New("dfjoin: appender is closed")
+
+// AppendGzipFile appends the gzip inputs to the last member of the gzip file at
+// path without rewriting it: the existing deflate data are inflated once to find
+// their final block, whose last-block bit is cleared in place, and the inputs
+// are spliced from there on followed by a new trailer. See GzipAppender for what
+// happens if it fails or crashes halfway.
+func AppendGzipFile(path string, inputs ...io.Reader) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("empty sources")
+	}
+	a, err := OpenGzipAppender(path)
+	if err != nil {
+		return err
+	}
+	if err = a.Append(inputs...); err != nil {
+		_ = a.Close()
+		return err
+	}
+	return a.Close()
+}
+
+// GzipAppender appends gzip inputs to a gzip file in place, see AppendGzipFile.
+// The file is valid at every step: the bytes an append overwrites are saved
+// to a sidecar journal, path + ".journal", which is synced before the file is
+// touched and removed once the appended file is synced. If an append fails the
+// file is restored right away, if it crashes the file is restored by the next
+// OpenGzipAppender. A GzipAppender must not be used concurrently, nor must the
+// file be written by anything else while it's open.
+type GzipAppender struct {
+	path string
+	f    *os.File
+	tail *gzipTail
+	opts []Option
+}
+
+// OpenGzipAppender recovers the gzip file at path from an unfinished append if
+// needed, and inflates it once to find where to append. opts are applied to
+// every Append, but for WithPrefix, WithSuffix, WithManifest and
+// WithEmbeddedManifest which don't apply to an append.
+func OpenGzipAppender(path string, opts ...Option) (*GzipAppender, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = recoverGzipFile(path, f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("unable to recover %s: %w", path, err)
+	}
+	tail, err := scanGzipTail(context.Background(), f, newJoinConfig(opts).lenientHeaderCRC)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("unable to scan %s: %w", path, err)
+	}
+	return &GzipAppender{path: path, f: f, tail: tail, opts: opts}, nil
+}
+
+// Append splices the gzip inputs at the end of the file and syncs it, either
+// all of them are appended or none.
+func (a *GzipAppender) Append(inputs ...io.Reader) error {
+	return a.AppendContext(context.Background(), inputs...)
+}
+
+// AppendContext is like Append but gives up as soon as ctx is done.
+func (a *GzipAppender) AppendContext(ctx context.Context, inputs ...io.Reader) (err error) {
+	if a.f == nil {
+		return errAppenderClosed
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	jr, err := a.journal()
+	if err != nil {
+		return err
+	}
+	if err = writeJournal(a.path, jr); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if ex := jr.rollback(a.f); ex != nil {
+				err = fmt.Errorf("%w, and unable to roll back: %v", err, ex)
+				return
+			}
+			if ex := removeJournal(a.path); ex != nil {
+				err = fmt.Errorf("%w, and %v", err, ex)
+			}
+		}
+	}()
+
+	tail, err := appendGzipTail(ctx, a.f, a.tail, inputs, a.opts)
+	if err != nil {
+		return fmt.Errorf("unable to append to %s: %w", a.path, err)
+	}
+	if err = a.f.Truncate(tail.end + 8); err != nil {
+		return fmt.Errorf("unable to truncate %s: %w", a.path, err)
+	}
+	if err = a.f.Sync(); err != nil {
+		return fmt.Errorf("unable to sync %s: %w", a.path, err)
+	}
+	if err = removeJournal(a.path); err != nil {
+		return err
+	}
+	a.tail = tail
+	return nil
+}
+
+// journal saves the bytes of the file overwritten by the next append.
+func (a *GzipAppender) journal() (*appendJournal, error) {
+	jr := &appendJournal{size: a.tail.end + 8, finalOff: a.tail.finalOff, off: a.tail.appendOff()}
+	b := make([]byte, 1)
+	if _, err := a.f.ReadAt(b, jr.finalOff); err != nil {
+		return nil, fmt.Errorf("unable to read the final block: %w", err)
+	}
+	jr.finalByte = b[0]
+	jr.tail = make([]byte, jr.size-jr.off)
+	if _, err := a.f.ReadAt(jr.tail, jr.off); err != nil {
+		return nil, fmt.Errorf("unable to read the trailer: %w", err)
+	}
+	return jr, nil
+}
+
+// Close closes the file.
+func (a *GzipAppender) Close() error {
+	if a.f == nil {
+		return errAppenderClosed
+	}
+	err := a.f.Close()
+	a.f = nil
+	return err
+}
+
+// scanGzipTail inflates the gzip stream r and checks it to find the end of its
+// last member.
+func scanGzipTail(ctx context.Context, r io.Reader, lenient bool) (*gzipTail, error) {
+	d := &deflateMerger{}
+	if err := d.init(io.Discard); err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	br := d.open(r)
+	crc32Checker := crc32.NewIEEE()
+	trailer := make([]byte, 8)
+
+	for member := 0; ; member++ {
+		if _, err := readGzipHeader(br, nil, lenient); err != nil {
+			return nil, newConcatError(d.pos(), fmt.Errorf("unable to read the gzip header of member %d: %w", member, err))
+		}
+
+		crc32Checker.Reset()
+		size, err := d.splice(ctx, br, crc32Checker)
+		if err != nil {
+			return nil, newConcatError(d.pos(), err)
+		}
+		tail := &gzipTail{
+			finalOff:  d.finalOff,
+			finalMask: d.finalMask,
+			end:       d.pos(),
+			lastByte:  d.lastByte,
+			lastBits:  d.lastBits,
+			crc32Sum:  crc32Checker.Sum32(),
+			size:      uint32(size),
+		}
+		d.lastBits = 0
+
+		if _, err = io.ReadFull(br, trailer); err != nil {
+			return nil, newConcatError(d.pos(), fmt.Errorf("unable to read gzip trailer of member %d: %w", member, err))
+		}
+		if err = checkGzipTrailer(trailer, tail.crc32Sum, size); err != nil {
+			return nil, newConcatError(d.pos(), fmt.Errorf("member %d: %w", member, err))
+		}
+
+		if _, err = br.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return tail, nil
+			}
+			return nil, fmt.Errorf("unable to read next member: %w", err)
+		}
+	}
+}
+
+// appendOff returns the offset from which the file is rewritten by an append,
+// the last byte of the deflate data is rewritten along with the pending bits.
+func (t *gzipTail) appendOff() int64 {
+	if t.lastBits > 0 {
+		return t.end - 1
+	}
+	return t.end
+}
+
+// appendGzipTail clears the last-block bit found by scanGzipTail in f, splices
+// the inputs right after the deflate data and writes the new trailer. It returns
+// the new tail of the file, f must be truncated after its trailer.
+func appendGzipTail(ctx context.Context, f *os.File, tail *gzipTail, inputs []io.Reader, opts []Option) (*gzipTail, error) {
+	off := tail.appendOff()
+	if tail.finalOff < off {
+		b := make([]byte, 1)
+		if _, err := f.ReadAt(b, tail.finalOff); err != nil {
+			return nil, fmt.Errorf("unable to read the final block: %w", err)
+		}
+		b[0] &^= tail.finalMask
+		if _, err := f.WriteAt(b, tail.finalOff); err != nil {
+			return nil, fmt.Errorf("unable to clear the last-block bit: %w", err)
+		}
+	}
+
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cfg := newJoinConfig(opts)
+	// the file ends with the trailer written here, a manifest member would be
+	// truncated away
+	cfg.prefix, cfg.suffix, cfg.manifest, cfg.embedManifest = nil, nil, nil, false
+	gm := &gzMerger{cfg: cfg, crc32Sum: tail.crc32Sum, checkSize32: tail.size, wroteHeader: true}
+	if err := gm.init(f); err != nil {
+		return nil, err
+	}
+	gm.lastByte, gm.lastBits = tail.lastByte, tail.lastBits
+	gm.bitShift = cfg.bitShift
+
+	// the file already holds data, the separator goes before every input
+	j := &Joiner{m: gm, cfg: cfg, started: true}
+	if err := j.appendAll(ctx, inputs); err != nil {
+		_ = j.Close()
+		return nil, err
+	}
+
+	// Close ends the deflate data with an empty last block, 10 bits written
+	// right after the pending bits, whose last byte only holds zero bits.
+	next := &gzipTail{
+		finalOff:  off + gm.written(),
+		finalMask: 1 << gm.lastBits,
+		lastBits:  (gm.lastBits + 10) % 8,
+	}
+	next.end = next.finalOff + int64(gm.lastBits+10+7)/8
+	if err := j.Close(); err != nil {
+		return nil, err
+	}
+	next.crc32Sum, next.size = gm.crc32Sum, gm.checkSize32
+	return next, nil
+}
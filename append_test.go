@@ -0,0 +1,192 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendGzipFile(t *testing.T) {
+	inputs := genTestInputs(20)
+	path := filepath.Join(t.TempDir(), "log.gz")
+
+	// a multi-member file, the inputs go to its last member
+	initial := append(gzCompress(inputs[0]), gzCompress(inputs[1])...)
+	if err := os.WriteFile(path, initial, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 2; i < len(inputs); i += 3 {
+		n := i + 3
+		if n > len(inputs) {
+			n = len(inputs)
+		}
+		var readers []io.Reader
+		for _, input := range inputs[i:n] {
+			readers = append(readers, bytes.NewReader(gzCompress(input)))
+		}
+		if err := AppendGzipFile(path, readers...); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := io.ReadAll(gr)
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, bytes.Join(inputs[:n], nil), plain)
+	}
+}
+
+func TestAppendCorruptGzipFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.gz")
+	corrupt := gzCompress(text4Test)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := AppendGzipFile(path, bytes.NewReader(gzCompress(text4Test)))
+	assert.ErrorIs(t, err, ErrCheckSize)
+
+	// nothing is written unless the file is valid
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, corrupt, data)
+}
+
+func readGzipFile(t *testing.T, path string) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func TestGzipAppender(t *testing.T) {
+	inputs := genTestInputs(20)
+	path := filepath.Join(t.TempDir(), "log.gz")
+	if err := os.WriteFile(path, gzCompress(inputs[0]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := OpenGzipAppender(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(inputs); i++ {
+		if err = a.Append(bytes.NewReader(gzCompress(inputs[i]))); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+		assert.Equal(t, bytes.Join(inputs[:i+1], nil), readGzipFile(t, path))
+	}
+
+	// a failed append leaves the file as it was
+	before, _ := os.ReadFile(path)
+	truncated := gzCompress(text4Test)
+	truncated = truncated[:len(truncated)/2]
+	assert.ErrorIs(t, a.Append(bytes.NewReader(gzCompress(text4Test)), bytes.NewReader(truncated)), io.ErrUnexpectedEOF)
+	after, _ := os.ReadFile(path)
+	assert.Equal(t, before, after)
+	assert.NoFileExists(t, journalPath(path))
+
+	if err = a.Append(bytes.NewReader(gzCompress(text4Test))); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, a.Close())
+	assert.ErrorIs(t, a.Append(bytes.NewReader(nil)), errAppenderClosed)
+	assert.Equal(t, append(bytes.Join(inputs, nil), text4Test...), readGzipFile(t, path))
+}
+
+func TestGzipAppenderRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.gz")
+	original := gzCompress(text4Test)
+	if err := os.WriteFile(path, original, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := OpenGzipAppender(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr, err := a.journal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeJournal(path, jr); err != nil {
+		t.Fatal(err)
+	}
+	// crash halfway through the append
+	_, _ = a.f.WriteAt([]byte{0}, a.tail.finalOff)
+	_, _ = a.f.WriteAt(bytes.Repeat([]byte{0xa5}, 100), jr.off)
+	_ = a.Close()
+
+	a, err = OpenGzipAppender(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Close()
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, original, data)
+	assert.NoFileExists(t, journalPath(path))
+
+	// crash while writing the journal, the file hasn't been touched
+	if err = os.WriteFile(journalPath(path), jr.marshal()[:20], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = AppendGzipFile(path, bytes.NewReader(gzCompress(text4Test))); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, append(append([]byte(nil), text4Test...), text4Test...), readGzipFile(t, path))
+	assert.NoFileExists(t, journalPath(path))
+}
+
+func TestGzipAppenderSeparator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.gz")
+	if err := os.WriteFile(path, gzCompress([]byte("a")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the prefix and the manifest don't apply to an append
+	a, err := OpenGzipAppender(path, WithSeparator([]byte("\n")), WithPrefix([]byte("[")), WithEmbeddedManifest(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if err = a.Append(bytes.NewReader(gzCompress([]byte("b"))), Plain([]byte("c"))); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Append(bytes.NewReader(gzCompress([]byte("d")))); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "a\nb\nc\nd", string(readGzipFile(t, path)))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte("a\nb\nc\nd"), readSingleMember(t, bytes.NewReader(data)))
+}
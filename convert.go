@@ -0,0 +1,135 @@
+package dfjoin
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+)
+
+// ConvertGzipToZlib rewrites the gzip stream r, made of a single member, as a
+// zlib stream. The deflate data are copied as is, they are inflated once to
+// check the trailer of r and to compute the Adler-32 of the zlib trailer. A
+// multi-member stream can be made a single member one by NormalizeGzip. Of the
+// options only WithLenientHeaderCRC applies.
+func ConvertGzipToZlib(w io.Writer, r io.Reader, opts ...Option) error {
+	return convert(w, r, FormatGzip, FormatZlib, opts)
+}
+
+// ConvertZlibToGzip rewrites the zlib stream r as a single member gzip stream,
+// see ConvertGzipToZlib. The gzip header is set by WithHeader and WithHeaderCRC.
+// A stream needing a preset dictionary fails with ErrZlibDict, as gzip has none.
+func ConvertZlibToGzip(w io.Writer, r io.Reader, opts ...Option) error {
+	return convert(w, r, FormatZlib, FormatGzip, opts)
+}
+
+// ConvertDeflateToGzip wraps the raw deflate stream r in a gzip member, see
+// ConvertZlibToGzip. Whatever follows the last block of r is ignored.
+func ConvertDeflateToGzip(w io.Writer, r io.Reader, opts ...Option) error {
+	return convert(w, r, FormatDeflate, FormatGzip, opts)
+}
+
+// ConvertDeflateToZlib wraps the raw deflate stream r in a zlib stream, see
+// ConvertGzipToZlib. Whatever follows the last block of r is ignored. It takes
+// options as the other conversions do, none of which applies to it yet.
+func ConvertDeflateToZlib(w io.Writer, r io.Reader, opts ...Option) error {
+	return convert(w, r, FormatDeflate, FormatZlib, opts)
+}
+
+// convert copies the deflate data of r from the format from to the format to,
+// with the header and the trailer of the latter.
+func convert(w io.Writer, r io.Reader, from, to Format, opts []Option) (err error) {
+	cfg := newJoinConfig(opts)
+	g := &gzMerger{cfg: cfg}
+	if err = g.init(w); err != nil {
+		return err
+	}
+	defer g.Close()
+	d := &g.deflateMerger
+	d.keepLast = true
+
+	br := d.open(r)
+	defer func() {
+		if err != nil {
+			err = newConcatError(d.pos(), err)
+		}
+	}()
+
+	switch from {
+	case FormatGzip:
+		if _, err = readGzipHeader(br, nil, cfg.lenientHeaderCRC); err != nil {
+			return fmt.Errorf("unable to read the gzip header: %w", err)
+		}
+	case FormatZlib:
+		_, dictID, fdict, err := readZlibHeader(br)
+		if err != nil {
+			return fmt.Errorf("unable to read the zlib header: %w", err)
+		}
+		if fdict {
+			return fmt.Errorf("%w: preset dictionary 0x%08x can't be carried over", ErrZlibDict, dictID)
+		}
+	}
+
+	switch to {
+	case FormatGzip:
+		err = g.writeHeader(nil)
+	case FormatZlib:
+		if _, err = d.w.Write(simpleZlibHeader); err != nil {
+			err = fmt.Errorf("unable to write zlib header: %w", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	crc32Checker, adler32Checker := crc32.NewIEEE(), adler32.New()
+	size, err := d.splice(context.Background(), br, io.MultiWriter(crc32Checker, adler32Checker))
+	if err != nil {
+		return err
+	}
+
+	switch from {
+	case FormatGzip:
+		trailer := make([]byte, 8)
+		if _, err = io.ReadFull(br, trailer); err != nil {
+			return fmt.Errorf("unable to read gzip trailer: %w", err)
+		}
+		if err = checkGzipTrailer(trailer, crc32Checker.Sum32(), size); err != nil {
+			return err
+		}
+		if _, err = br.Peek(1); err == nil {
+			return fmt.Errorf("unable to convert a multi-member gzip stream")
+		} else if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("unable to read next member: %w", err)
+		}
+	case FormatZlib:
+		checksumBytes := make([]byte, 4)
+		if _, err = io.ReadFull(br, checksumBytes); err != nil {
+			return fmt.Errorf("unable to read zlib trailer: %w", err)
+		}
+		if adler32Sum := binary.BigEndian.Uint32(checksumBytes); adler32Checker.Sum32() != adler32Sum {
+			return fmt.Errorf("%w: expect 0x%x, got 0x%x", ErrZlibSum, adler32Checker.Sum32(), adler32Sum)
+		}
+	}
+
+	var trailer []byte
+	switch to {
+	case FormatGzip:
+		trailer = make([]byte, 8)
+		binary.LittleEndian.PutUint32(trailer, crc32Checker.Sum32())
+		binary.LittleEndian.PutUint32(trailer[4:], uint32(size))
+	case FormatZlib:
+		trailer = make([]byte, 4)
+		binary.BigEndian.PutUint32(trailer, adler32Checker.Sum32())
+	}
+	if _, err = d.w.Write(trailer); err != nil {
+		return fmt.Errorf("unable to output %s trailer: %w", to, err)
+	}
+	if err = d.w.Flush(); err != nil {
+		return fmt.Errorf("unable to flush write buffer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvert(t *testing.T) {
+	gz := gzCompress(text4Test)
+
+	zl := new(bytes.Buffer)
+	if err := ConvertGzipToZlib(zl, bytes.NewReader(gz)); err != nil {
+		t.Fatal(err)
+	}
+	// the deflate data are copied as is
+	assert.Equal(t, gz[10:len(gz)-8], zl.Bytes()[2:zl.Len()-4])
+	zr, err := zlib.NewReader(bytes.NewReader(zl.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, text4Test, data)
+
+	back := new(bytes.Buffer)
+	err = ConvertZlibToGzip(back, bytes.NewReader(zl.Bytes()), WithHeader(gzip.Header{Name: "text.txt"}), WithHeaderCRC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := readJoined(t, back.Bytes(), text4Test)
+	assert.Equal(t, "text.txt", hdr.Name)
+	assert.Equal(t, gz[10:len(gz)-8], back.Bytes()[10+9+2:back.Len()-8])
+
+	raw := new(bytes.Buffer)
+	fw, _ := flate.NewWriter(raw, flate.BestSpeed)
+	_, _ = fw.Write(text4Test)
+	_ = fw.Close()
+	withGarbage := append(append([]byte(nil), raw.Bytes()...), "garbage"...)
+
+	wrapped := new(bytes.Buffer)
+	if err = ConvertDeflateToGzip(wrapped, bytes.NewReader(withGarbage)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, text4Test, readSingleMember(t, bytes.NewReader(wrapped.Bytes())))
+	assert.Equal(t, raw.Bytes(), wrapped.Bytes()[10:wrapped.Len()-8])
+
+	wrapped.Reset()
+	if err = ConvertDeflateToZlib(wrapped, bytes.NewReader(withGarbage)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, raw.Bytes(), wrapped.Bytes()[2:wrapped.Len()-4])
+	zr, err = NewZlibReader(bytes.NewReader(wrapped.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, text4Test, data)
+}
+
+func TestConvertErrors(t *testing.T) {
+	gz := gzCompress(text4Test)
+
+	corrupt := append([]byte(nil), gz...)
+	corrupt[len(corrupt)-5]++
+	err := ConvertGzipToZlib(io.Discard, bytes.NewReader(corrupt))
+	assert.ErrorIs(t, err, ErrChecksum)
+
+	err = ConvertGzipToZlib(io.Discard, bytes.NewReader(append(gz, gz...)))
+	assert.Error(t, err)
+
+	dictOut := new(bytes.Buffer)
+	zw, _ := zlib.NewWriterLevelDict(dictOut, zlib.DefaultCompression, []byte("dictionary"))
+	_, _ = zw.Write(text4Test)
+	_ = zw.Close()
+	err = ConvertZlibToGzip(io.Discard, bytes.NewReader(dictOut.Bytes()))
+	assert.ErrorIs(t, err, ErrZlibDict)
+
+	zl := new(bytes.Buffer)
+	if err = ConvertGzipToZlib(zl, bytes.NewReader(gz)); err != nil {
+		t.Fatal(err)
+	}
+	corrupt = zl.Bytes()
+	corrupt[len(corrupt)-1]++
+	err = ConvertZlibToGzip(io.Discard, bytes.NewReader(corrupt))
+	assert.ErrorIs(t, err, ErrZlibSum)
+	var ce *ConcatError
+	assert.ErrorAs(t, err, &ce)
+}
@@ -0,0 +1,158 @@
+package dfjoin
+
+import (
+	"context"
+	"fmt"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+)
+
+// DeflateSums holds the checksums of the data joined by a raw deflate Joiner,
+// which has no trailer to record them.
+type DeflateSums struct {
+	CRC32   uint32
+	Adler32 uint32
+	Size    int64 // uncompressed size
+}
+
+// WithDeflateSums makes a raw deflate Joiner fill s with the CRC-32, the Adler-32
+// and the size of the joined data when it's closed. It has no effect on gzip and
+// zlib joiners.
+func WithDeflateSums(s *DeflateSums) Option {
+	return func(c *joinConfig) {
+		c.sums = s
+	}
+}
+
+// ConcatDeflate joins the raw deflate inputs into a single raw deflate stream.
+// Whatever follows the last block of an input is ignored.
+func ConcatDeflate(w io.Writer, inputs ...io.Reader) error {
+	return concatDeflate(context.Background(), w, inputs, nil)
+}
+
+// ConcatDeflateWith is like ConcatDeflate but takes options.
+func ConcatDeflateWith(w io.Writer, inputs []io.Reader, opts ...Option) error {
+	return concatDeflate(context.Background(), w, inputs, opts)
+}
+
+// ConcatDeflateContext is like ConcatDeflate but stops inflating as soon as ctx is done.
+func ConcatDeflateContext(ctx context.Context, w io.Writer, inputs ...io.Reader) error {
+	return concatDeflate(ctx, w, inputs, nil)
+}
+
+func concatDeflate(ctx context.Context, w io.Writer, inputs []io.Reader, opts []Option) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("empty sources")
+	}
+
+	j, err := NewDeflateJoiner(w, opts...)
+	if err != nil {
+		return err
+	}
+	if err = j.appendAll(ctx, inputs); err != nil {
+		_ = j.Close()
+		return fmt.Errorf("unable to concat deflate: %w", err)
+	}
+	return j.Close()
+}
+
+// NewDeflateJoiner returns a Joiner for raw deflate inputs, nothing is written to
+// w until the first input is appended.
+func NewDeflateJoiner(w io.Writer, opts ...Option) (*Joiner, error) {
+	cfg := newJoinConfig(opts)
+	rm, err := newRawMerger(w, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Joiner{m: rm, cfg: cfg}, nil
+}
+
+type rawMerger struct {
+	deflateMerger
+	cfg  *joinConfig
+	sums DeflateSums
+}
+
+func newRawMerger(w io.Writer, cfg *joinConfig) (*rawMerger, error) {
+	rm := &rawMerger{cfg: cfg, sums: DeflateSums{Adler32: 1}}
+	if err := rm.init(w); err != nil {
+		return nil, err
+	}
+	rm.bitShift = cfg.bitShift
+	return rm, nil
+}
+
+func (d *rawMerger) concat(ctx context.Context, r io.Reader) (err error) {
+	br := d.open(r)
+	defer func() {
+		if err != nil {
+			err = newConcatError(d.pos(), err)
+		}
+	}()
+
+	crc32Checker := crc32.NewIEEE()
+	adler32Checker := adler32.New()
+	if _, err = d.splice(ctx, br, io.MultiWriter(crc32Checker, adler32Checker)); err != nil {
+		return err
+	}
+
+	d.in.Checksum = crc32Checker.Sum32()
+	d.in.adler32Sum = adler32Checker.Sum32()
+	d.combine(d.in)
+	return nil
+}
+
+func (d *rawMerger) combine(in *InputStats) {
+	d.sums.CRC32 = IEEECrc32Combine(d.sums.CRC32, in.Checksum, in.UncompressedBytes)
+	d.sums.Adler32 = Adler32Combine(d.sums.Adler32, in.adler32Sum, in.UncompressedBytes)
+	d.sums.Size += in.UncompressedBytes
+}
+
+func (d *rawMerger) checksum(in *InputStats, crc32Sum, adler32Sum uint32) {
+	in.Checksum = crc32Sum
+	in.adler32Sum = adler32Sum
+}
+
+func (d *rawMerger) format() Format {
+	return FormatDeflate
+}
+
+func (d *rawMerger) worker() (merger, error) {
+	return newRawMerger(io.Discard, d.cfg)
+}
+
+func (d *rawMerger) finish() error {
+	if err := d.deflateMerger.finish(); err != nil {
+		return err
+	}
+	if err := d.w.Flush(); err != nil {
+		return fmt.Errorf("unable to flush write buffer: %w", err)
+	}
+	if d.cfg.sums != nil {
+		*d.cfg.sums = d.sums
+	}
+	return nil
+}
+
+type deflateReader struct {
+	inflater
+}
+
+func (d *deflateReader) Read(p []byte) (n int, err error) {
+	n, err = d.read(p)
+	if err != nil && err != io.EOF {
+		err = d.concatError(0, err)
+	}
+	return n, err
+}
+
+// NewDeflateReader returns an io.ReadCloser which decompresses the raw deflate
+// stream read from r, it stops at the end of the last block.
+func NewDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	dr := &deflateReader{}
+	if err := dr.init(r); err != nil {
+		return nil, err
+	}
+	return dr, nil
+}
@@ -0,0 +1,75 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/flate"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func flateCompress(p []byte, level int) []byte {
+	out := new(bytes.Buffer)
+	fw, _ := flate.NewWriter(out, level)
+	_, _ = fw.Write(p)
+	_ = fw.Close()
+	return out.Bytes()
+}
+
+func TestConcatDeflate(t *testing.T) {
+	inputs := genTestInputs(50)
+
+	readers := make([]io.Reader, len(inputs))
+	for i, input := range inputs {
+		readers[i] = bytes.NewReader(flateCompress(input, i%10))
+	}
+
+	var sums DeflateSums
+	joined := new(bytes.Buffer)
+	if err := ConcatDeflateWith(joined, readers, WithDeflateSums(&sums)); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := io.ReadAll(flate.NewReader(bytes.NewReader(joined.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bytes.Join(inputs, nil)
+	assert.Equal(t, expected, plain)
+	assert.Equal(t, crc32.ChecksumIEEE(expected), sums.CRC32)
+	assert.Equal(t, adler32.Checksum(expected), sums.Adler32)
+	assert.Equal(t, int64(len(expected)), sums.Size)
+
+	dr, err := NewDeflateReader(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dr.Close()
+	plain, err = io.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, plain)
+}
+
+func TestCorruptDeflateStream(t *testing.T) {
+	compressed := flateCompress(text4Test, flate.BestCompression)
+
+	dr, err := NewDeflateReader(bytes.NewReader(compressed[:len(compressed)/2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dr.Close()
+	_, err = io.ReadAll(dr)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	err = ConcatDeflate(io.Discard, bytes.NewReader(compressed), bytes.NewReader([]byte{0xff, 0xff, 0xff}))
+	var ce *ConcatError
+	if assert.ErrorAs(t, err, &ce) {
+		assert.Equal(t, 1, ce.Index)
+	}
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
@@ -0,0 +1,108 @@
+package dfjoin
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+
+	"github.com/zhyee/deflatejoin/internal"
+)
+
+/*
+#include "dfjoin.h"
+*/
+import "C"
+
+// Compression levels of zlib's deflate.
+const (
+	NoCompression      = 0
+	BestSpeed          = 1
+	BestCompression    = 9
+	DefaultCompression = -1
+)
+
+// Compression strategies of zlib's deflate, see deflateInit2 in zlib.h.
+const (
+	DefaultStrategy = 0
+	Filtered        = 1
+	HuffmanOnly     = 2
+	RLE             = 3
+	Fixed           = 4
+)
+
+// deflater compresses data with zlib's deflate, the input and output buffers
+// are allocated by C since z_stream keeps pointing to them between calls.
+type deflater struct {
+	stream    *C.z_stream
+	inputBuf  *C.uchar
+	outputBuf *C.uchar
+}
+
+// newDeflater returns a deflater, see deflateInit2 for the meaning of the arguments.
+func newDeflater(level, windowBits, memLevel, strategy int) (*deflater, error) {
+	z := &deflater{stream: new(C.z_stream)}
+	if ret := C.initDeflate(z.stream, C.int(level), C.int(windowBits), C.int(memLevel), C.int(strategy)); ret != C.Z_OK {
+		return nil, fmt.Errorf("unable to init deflate, level %d, strategy %d: error code %d", level, strategy, int(ret))
+	}
+
+	inBuf := C.malloc(BufSize)
+	outBuf := C.malloc(BufSize)
+	z.inputBuf = (*C.uchar)(inBuf)
+	z.outputBuf = (*C.uchar)(outBuf)
+	if inBuf == nil || outBuf == nil {
+		_ = z.Close()
+		errMessage := C.errMessage()
+		return nil, fmt.Errorf("unable to malloc buffer memory: %s",
+			internal.UnsafeString((*byte)(unsafe.Pointer(errMessage)), int(C.strlen(errMessage))))
+	}
+	return z, nil
+}
+
+// write compresses p to w, flush is passed to deflate along with the last
+// piece of p, it's called once even if p is empty.
+func (z *deflater) write(w io.Writer, p []byte, flush C.int) error {
+	in := unsafe.Slice((*byte)(unsafe.Pointer(z.inputBuf)), BufSize)
+	out := unsafe.Slice((*byte)(unsafe.Pointer(z.outputBuf)), BufSize)
+
+	for {
+		n := copy(in, p)
+		p = p[n:]
+		z.stream.next_in = z.inputBuf
+		z.stream.avail_in = C.uint(n)
+
+		mode := C.int(C.Z_NO_FLUSH)
+		if len(p) == 0 {
+			mode = flush
+		}
+		for {
+			z.stream.next_out = z.outputBuf
+			z.stream.avail_out = BufSize
+			ret := C.deflate(z.stream, mode)
+			if ret == C.Z_STREAM_ERROR {
+				return fmt.Errorf("unable to deflate, error code: %d", int(ret))
+			}
+			if _, err := w.Write(out[:BufSize-int(z.stream.avail_out)]); err != nil {
+				return fmt.Errorf("unable to output compressed data: %w", err)
+			}
+			if z.stream.avail_out != 0 {
+				break
+			}
+		}
+		if len(p) == 0 {
+			return nil
+		}
+	}
+}
+
+func (z *deflater) Close() error {
+	C.deflateEnd(z.stream)
+	if z.inputBuf != nil {
+		C.free(unsafe.Pointer(z.inputBuf))
+		z.inputBuf = nil
+	}
+	if z.outputBuf != nil {
+		C.free(unsafe.Pointer(z.outputBuf))
+		z.outputBuf = nil
+	}
+	return nil
+}
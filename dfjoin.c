@@ -11,6 +11,13 @@ int initStream(z_stream *stream) {
 	return inflateInit2(stream, -15);
 }
 
+int initDeflate(z_stream *stream, int level, int windowBits, int memLevel, int strategy) {
+	stream->zalloc = Z_NULL;
+	stream->zfree = Z_NULL;
+	stream->opaque = Z_NULL;
+	return deflateInit2(stream, level, Z_DEFLATED, windowBits, memLevel, strategy);
+}
+
 char *errMessage() {
 	return strerror(errno);
 }
\ No newline at end of file
@@ -9,6 +9,7 @@
 //see https://github.com/madler/zlib/blob/develop/examples/gzjoin.c
 
 int initStream(z_stream *stream);
+int initDeflate(z_stream *stream, int level, int windowBits, int memLevel, int strategy);
 char *errMessage();
 
 #endif /* _HEADER_DFJOIN_H */
\ No newline at end of file
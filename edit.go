@@ -0,0 +1,127 @@
+package dfjoin
+
+import (
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+)
+
+// errUnalignedSegment is returned for a segment which doesn't start on a byte
+// boundary, such as those of an output joined WithBitShift, whose bits would have
+// to be shifted.
+var errUnalignedSegment = errors.New("dfjoin: segment not byte aligned")
+
+// SelectSegments writes to w a new joined stream, in the format of m, made of the
+// segments of the joined stream r described by m, in the order given by order.
+// Segments left out of order are removed. The deflate data of the segments are
+// copied as is, see Joiner.AppendSegment, and the options are those of the
+// Joiner. To replace a segment, use a Joiner and Append the new input in place
+// of the segment.
+func SelectSegments(w io.Writer, r io.ReaderAt, m *Manifest, order []int, opts ...Option) error {
+	var (
+		j   *Joiner
+		err error
+	)
+	switch m.Format {
+	case FormatGzip:
+		j, err = NewGzipJoiner(w, opts...)
+	case FormatZlib:
+		j, err = NewZlibJoiner(w, opts...)
+	case FormatDeflate:
+		j, err = NewDeflateJoiner(w, opts...)
+	default:
+		return fmt.Errorf("unknown format %v", m.Format)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, i := range order {
+		if err = j.AppendSegment(r, m, i); err != nil {
+			_ = j.Close()
+			return fmt.Errorf("unable to select segments: %w", err)
+		}
+	}
+	return j.Close()
+}
+
+// AppendSegment appends the segment i of the joined stream r described by m, its
+// deflate data are copied as is rather than inflated. Its checksum is taken from
+// m if it's of the kind the output needs, otherwise, or if WithStrict is set,
+// the segment is inflated to compute it.
+func (j *Joiner) AppendSegment(r io.ReaderAt, m *Manifest, i int) error {
+	if j.closed {
+		return errJoinerClosed
+	}
+	if j.err != nil {
+		return j.err
+	}
+	if err := j.boundary(); err != nil {
+		return err
+	}
+
+	d := j.m.base()
+	d.in = &InputStats{}
+	if err := j.mark(false); err != nil {
+		return j.done(err)
+	}
+	return j.done(j.copySegment(r, m, i))
+}
+
+func (j *Joiner) copySegment(r io.ReaderAt, m *Manifest, i int) error {
+	if i < 0 || i >= len(m.Segments) {
+		return fmt.Errorf("segment %d out of range [0, %d)", i, len(m.Segments))
+	}
+	seg := m.Segments[i]
+	if seg.Bit != 0 {
+		return fmt.Errorf("segment %d: %w", i, errUnalignedSegment)
+	}
+
+	d := j.m.base()
+	if m.Format == j.m.format() && m.Format != FormatDeflate && !j.cfg.strict {
+		d.in.Checksum = seg.Checksum
+	} else {
+		crc32Sum, adler32Sum, err := segmentSums(r, m, i)
+		if err != nil {
+			return err
+		}
+		j.m.checksum(d.in, crc32Sum, adler32Sum)
+	}
+
+	sr := io.NewSectionReader(r, seg.Offset, (seg.Bits+7)/8)
+	if _, err := io.CopyN(d.w, sr, seg.Bits/8); err != nil {
+		return fmt.Errorf("unable to copy segment %d: %w", i, err)
+	}
+	if rest := uint(seg.Bits % 8); rest > 0 {
+		// the bits of the last byte beyond the segment belong to what follows
+		var b [1]byte
+		if _, err := io.ReadFull(sr, b[:]); err != nil {
+			return fmt.Errorf("unable to copy segment %d: %w", i, err)
+		}
+		d.lastByte, d.lastBits = b[0]&(1<<rest-1), rest
+	}
+
+	d.in.CompressedBytes = sr.Size()
+	d.in.UncompressedBytes = seg.UncompressedBytes
+	j.m.combine(d.in)
+	return nil
+}
+
+// segmentSums inflates the segment i to compute its CRC-32 and Adler-32, the
+// checksum recorded by m is verified as well.
+func segmentSums(r io.ReaderAt, m *Manifest, i int) (crc32Sum, adler32Sum uint32, err error) {
+	sr, err := ExtractSegment(r, m, i)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer sr.Close()
+
+	crc32Checker := crc32.NewIEEE()
+	adler32Checker := adler32.New()
+	if _, err = io.Copy(io.MultiWriter(crc32Checker, adler32Checker), sr); err != nil {
+		return 0, 0, fmt.Errorf("segment %d: %w", i, err)
+	}
+	return crc32Checker.Sum32(), adler32Checker.Sum32(), nil
+}
@@ -0,0 +1,75 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectSegments(t *testing.T) {
+	inputs := genTestInputs(10)
+	var readers []io.Reader
+	for _, input := range inputs {
+		readers = append(readers, bytes.NewReader(gzCompress(input)))
+	}
+
+	var m Manifest
+	joined := new(bytes.Buffer)
+	if err := ConcatGzipWith(joined, readers, WithManifest(&m)); err != nil {
+		t.Fatal(err)
+	}
+	ra := bytes.NewReader(joined.Bytes())
+
+	order := []int{9, 0, 4, 3, 8}
+	var edited Manifest
+	out := new(bytes.Buffer)
+	if err := SelectSegments(out, ra, &m, order, WithManifest(&edited), WithStrict()); err != nil {
+		t.Fatal(err)
+	}
+	var expected [][]byte
+	for _, i := range order {
+		expected = append(expected, inputs[i])
+	}
+	assert.Equal(t, bytes.Join(expected, nil), readSingleMember(t, out))
+	assert.Len(t, edited.Segments, len(order))
+
+	// replace a segment, and convert the segments to zlib
+	out.Reset()
+	j, err := NewZlibJoiner(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range inputs {
+		if i == 5 {
+			err = j.Append(Plain([]byte("replaced")))
+		} else {
+			err = j.AppendSegment(ra, &m, i)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zlib.NewReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append([][]byte(nil), inputs...)
+	expected[5] = []byte("replaced")
+	assert.Equal(t, bytes.Join(expected, nil), plain)
+
+	// a corrupt checksum in the manifest is caught in strict mode
+	m.Segments[2].Checksum ^= 1
+	err = SelectSegments(io.Discard, ra, &m, []int{2}, WithStrict())
+	assert.ErrorIs(t, err, gzip.ErrChecksum)
+}
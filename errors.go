@@ -0,0 +1,85 @@
+package dfjoin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+#include "dfjoin.h"
+*/
+import "C"
+
+// ErrCorrupt is reported when zlib finds the deflate data invalid (Z_DATA_ERROR).
+var ErrCorrupt = errors.New("deflate: corrupt data")
+
+// ConcatError is the error returned for a failing input by the concat functions
+// and the Joiner, as well as by the readers returned by NewGzipReader and
+// NewZlibReader, for which Index is the index of the gzip member being read.
+type ConcatError struct {
+	Index  int    // index of the input
+	Offset int64  // offset of the compressed data within the input where the error occurred
+	Code   int    // zlib return code, 0 if the error doesn't come from zlib
+	Msg    string // zlib's own message, if any
+	Err    error
+}
+
+func (e *ConcatError) Error() string {
+	return fmt.Sprintf("input %d, offset %d: %v", e.Index, e.Offset, e.Err)
+}
+
+func (e *ConcatError) Unwrap() error {
+	return e.Err
+}
+
+// inflateError is returned when inflate fails, offset is the number of bytes
+// consumed from the input buffer when it happened.
+type inflateError struct {
+	code   int
+	msg    string
+	offset int64
+}
+
+func newInflateError(ret C.int, stream *C.z_stream) *inflateError {
+	ie := &inflateError{code: int(ret)}
+	if stream.msg != nil {
+		ie.msg = C.GoString(stream.msg)
+	}
+	return ie
+}
+
+func (e *inflateError) Error() string {
+	if e.msg == "" {
+		return fmt.Sprintf("unable to inflate, error code: %d(%s)", e.code, inflateErrors[e.code])
+	}
+	return fmt.Sprintf("unable to inflate, error code: %d(%s): %s", e.code, inflateErrors[e.code], e.msg)
+}
+
+func (e *inflateError) Unwrap() error {
+	switch e.code {
+	case int(C.Z_DATA_ERROR):
+		return ErrCorrupt
+	case int(C.Z_BUF_ERROR):
+		// inflate is unable to make any progress, the input is truncated
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// newConcatError turns err into a *ConcatError, pos is the offset of the input
+// consumed when err occurred.
+func newConcatError(pos int64, err error) *ConcatError {
+	var ce *ConcatError
+	if errors.As(err, &ce) {
+		return ce
+	}
+	ce = &ConcatError{Offset: pos, Err: err}
+	var ie *inflateError
+	if errors.As(err, &ie) {
+		ce.Offset += ie.offset
+		ce.Code = ie.code
+		ce.Msg = ie.msg
+	}
+	return ce
+}
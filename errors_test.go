@@ -0,0 +1,113 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcatError(t *testing.T) {
+	gz := gzCompress(bytes.Repeat(text4Test, 100))
+
+	t.Run("corrupt", func(t *testing.T) {
+		corrupt := append([]byte(nil), gz...)
+		for i := 20; i < 40; i++ {
+			corrupt[i] = 0xff
+		}
+
+		err := ConcatGzip(io.Discard, bytes.NewReader(gz), bytes.NewReader(corrupt))
+		var ce *ConcatError
+		if !errors.As(err, &ce) {
+			t.Fatalf("expect a ConcatError, got %v(%T)", err, err)
+		}
+		t.Log(err)
+		assert.Equal(t, 1, ce.Index)
+		assert.Equal(t, -3, ce.Code)
+		assert.NotEmpty(t, ce.Msg)
+		assert.Greater(t, ce.Offset, int64(10))
+		assert.Less(t, ce.Offset, int64(len(corrupt)))
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		err := ConcatGzip(io.Discard, bytes.NewReader(gz), bytes.NewReader(gz), bytes.NewReader(gz[:len(gz)/2]))
+		var ce *ConcatError
+		if !errors.As(err, &ce) {
+			t.Fatalf("expect a ConcatError, got %v(%T)", err, err)
+		}
+		assert.Equal(t, 2, ce.Index)
+		assert.Equal(t, int64(len(gz)/2), ce.Offset)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("header", func(t *testing.T) {
+		err := ConcatGzip(io.Discard, bytes.NewReader(gz), bytes.NewReader(text4Test))
+		var ce *ConcatError
+		if !errors.As(err, &ce) {
+			t.Fatalf("expect a ConcatError, got %v(%T)", err, err)
+		}
+		assert.Equal(t, 1, ce.Index)
+		assert.ErrorIs(t, err, ErrHeader)
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		corrupt := append([]byte(nil), gz...)
+		for i := 20; i < 40; i++ {
+			corrupt[i] = 0xff
+		}
+		gr, err := NewGzipReader(bytes.NewReader(corrupt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gr.Close()
+
+		_, err = io.Copy(io.Discard, gr)
+		var ce *ConcatError
+		if !errors.As(err, &ce) {
+			t.Fatalf("expect a ConcatError, got %v(%T)", err, err)
+		}
+		assert.Equal(t, -3, ce.Code)
+		assert.ErrorIs(t, err, ErrCorrupt)
+
+		_, err = NewZlibReader(bytes.NewReader(gz))
+		assert.True(t, errors.As(err, &ce))
+	})
+
+	t.Run("reader-checksum", func(t *testing.T) {
+		corrupt := append([]byte(nil), gz...)
+		corrupt[len(corrupt)-8]++
+		gr, err := NewGzipReader(bytes.NewReader(corrupt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gr.Close()
+
+		_, err = io.Copy(io.Discard, gr)
+		var ce *ConcatError
+		assert.True(t, errors.As(err, &ce))
+		assert.ErrorIs(t, err, gzip.ErrChecksum)
+	})
+
+	t.Run("reader-member", func(t *testing.T) {
+		// the checksum of the second member is wrong
+		corrupt := append(append([]byte(nil), gz...), gz...)
+		corrupt[len(corrupt)-8]++
+		gr, err := NewGzipReader(bytes.NewReader(corrupt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gr.Close()
+
+		_, err = io.Copy(io.Discard, gr)
+		var ce *ConcatError
+		if !errors.As(err, &ce) {
+			t.Fatalf("expect a ConcatError, got %v(%T)", err, err)
+		}
+		assert.Equal(t, 1, ce.Index)
+		assert.ErrorIs(t, err, gzip.ErrChecksum)
+	})
+}
@@ -2,16 +2,14 @@ package dfjoin
 
 import (
 	"bufio"
-	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
-	"unsafe"
-
-	"github.com/zhyee/deflatejoin/internal"
+	"time"
 )
 
 /*
@@ -29,56 +27,86 @@ var (
 	ErrCheckSize  = errors.New("gzip: invalid trailer size")
 	ErrZlibHeader = errors.New("zlib: invalid header")
 	ErrZlibSum    = errors.New("zlib: invalid checksum")
+	ErrZlibDict   = errors.New("zlib: invalid dictionary")
+	// ErrHeaderCRC is a mismatch of the gzip header CRC, it matches ErrHeader
+	// with errors.Is.
+	ErrHeaderCRC = fmt.Errorf("%w: header crc mismatch", ErrHeader)
 )
 
 var simpleGzipHeader = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff")
 
-type gzReader struct {
-	inflater
-	crc32Sum    uint32
-	checkSize32 uint32
+// ConcatGzip joins the gzip inputs into a single member gzip stream, inputs
+// which have more than one member are accepted as well. Members written by a
+// JoinReadyWriter are copied without being inflated if the input is an
+// io.Seeker, see JoinReadyWriter.
+func ConcatGzip(w io.Writer, inputs ...io.Reader) error {
+	return concatGzip(context.Background(), w, inputs, nil)
 }
 
-func ConcatGzip(w io.Writer, inputs ...io.Reader) error {
+// ConcatGzipWith is like ConcatGzip but takes options.
+func ConcatGzipWith(w io.Writer, inputs []io.Reader, opts ...Option) error {
+	return concatGzip(context.Background(), w, inputs, opts)
+}
+
+// ConcatGzipContext is like ConcatGzip but stops inflating as soon as ctx is done.
+func ConcatGzipContext(ctx context.Context, w io.Writer, inputs ...io.Reader) error {
+	return concatGzip(ctx, w, inputs, nil)
+}
+
+func concatGzip(ctx context.Context, w io.Writer, inputs []io.Reader, opts []Option) error {
 	if len(inputs) == 0 {
 		return fmt.Errorf("empty sources")
 	}
 
-	switch len(inputs) {
-	case 0:
-		return fmt.Errorf("empty sources")
-	case 1:
-		_, err := io.Copy(w, inputs[0])
-		return err
-	default:
-		gm, err := newGzMerger(w)
-		if err != nil {
-			return fmt.Errorf("unable to write gzip header: %w", err)
-		}
-		defer gm.Close()
-		for i, r := range inputs {
-			if err = gm.concat(r, i == len(inputs)-1); err != nil {
-				return fmt.Errorf("unable to concat gzip: %w", err)
-			}
+	j, err := NewGzipJoiner(w, opts...)
+	if err != nil {
+		return fmt.Errorf("unable to write gzip header: %w", err)
+	}
+	if j.cfg.newestModTime {
+		if inputs, j.cfg.modTime, err = newestModTime(inputs, j.cfg.lenientHeaderCRC); err != nil {
+			_ = j.Close()
+			return fmt.Errorf("unable to read gzip headers: %w", err)
 		}
 	}
+	if err = j.appendAll(ctx, inputs); err != nil {
+		_ = j.Close()
+		return fmt.Errorf("unable to concat gzip: %w", err)
+	}
+	return j.Close()
+}
+
+func checkGzipTrailer(trailer []byte, crc32Sum uint32, size int64) error {
+	trailerCrc32 := binary.LittleEndian.Uint32(trailer[:4])
+	if crc32Sum != trailerCrc32 {
+		return fmt.Errorf("%w: expect 0x%x, got 0x%x", ErrChecksum, crc32Sum, trailerCrc32)
+	}
+	checkSize := binary.LittleEndian.Uint32(trailer[4:])
+	if uint32(size) != checkSize {
+		return fmt.Errorf("%w: expect %d, got %d", ErrCheckSize, uint32(size), checkSize)
+	}
 	return nil
 }
 
-func (g *gzReader) readHeader() (int, error) {
-	return readGzipHeader(g.br)
+// NormalizeGzip rewrites the possibly multi-member gzip stream r as a single
+// member gzip stream, the deflate data is copied without recompression.
+func NormalizeGzip(w io.Writer, r io.Reader) error {
+	return ConcatGzip(w, r)
 }
 
-func readGzipHeader(r *bufio.Reader) (int, error) {
+// readGzipHeader reads a gzip member header from r, and fills hdr with its
+// fields unless hdr is nil. The header CRC is checked if there is one, unless
+// lenient is true.
+func readGzipHeader(r *bufio.Reader, hdr *gzip.Header, lenient bool) (int, error) {
 	skipBytes := 0
+	digest := crc32.NewIEEE()
 
-	var magic [3]byte
-	n, err := io.ReadFull(r, magic[:])
+	var fixed [10]byte
+	n, err := io.ReadFull(r, fixed[:3])
 	skipBytes += n
 	if err != nil {
 		return skipBytes, fmt.Errorf("unable to read gzip magic: %w", err)
 	}
-	if magic[0] != 0x1f || magic[1] != 0x8b || magic[2] != 8 {
+	if fixed[0] != 0x1f || fixed[1] != 0x8b || fixed[2] != 8 {
 		return skipBytes, ErrHeader
 	}
 	flags, err := r.ReadByte()
@@ -89,398 +117,409 @@ func readGzipHeader(r *bufio.Reader) (int, error) {
 	if flags&0xe0 != 0 {
 		return skipBytes, fmt.Errorf("unknown reserved bits set")
 	}
-	discarded, err := r.Discard(6)
+	n, err = io.ReadFull(r, fixed[4:])
+	skipBytes += n
 	if err != nil {
-		return skipBytes, fmt.Errorf("unable to skip bytes: %w", err)
+		return skipBytes, fmt.Errorf("unable to read gzip header: %w", err)
+	}
+	fixed[3] = flags
+	digest.Write(fixed[:])
+	if hdr != nil {
+		*hdr = gzip.Header{OS: fixed[9]}
+		if mtime := binary.LittleEndian.Uint32(fixed[4:8]); mtime > 0 {
+			hdr.ModTime = time.Unix(int64(mtime), 0)
+		}
 	}
-	skipBytes += discarded
 
-	// skip extra field
+	// extra field
 	if flags&4 != 0 {
-		var extraLen uint16
-		if err = binary.Read(r, binary.LittleEndian, &extraLen); err != nil {
+		var extraLen [2]byte
+		if _, err = io.ReadFull(r, extraLen[:]); err != nil {
 			return skipBytes, fmt.Errorf("unable to read extra field length: %w", err)
 		}
 		skipBytes += 2
-		if extraLen > 0 {
-			if discarded, err = r.Discard(int(extraLen)); err != nil {
-				return skipBytes, fmt.Errorf("unable to skip bytes: %w", err)
-			}
-			skipBytes += discarded
+		extra := make([]byte, binary.LittleEndian.Uint16(extraLen[:]))
+		n, err = io.ReadFull(r, extra)
+		skipBytes += n
+		if err != nil {
+			return skipBytes, fmt.Errorf("unable to read extra field: %w", err)
+		}
+		digest.Write(extraLen[:])
+		digest.Write(extra)
+		if hdr != nil {
+			hdr.Extra = extra
 		}
 	}
 
-	// skip file name
+	// file name
 	if flags&8 != 0 {
-		for {
-			b, err := r.ReadByte()
-			if err != nil {
-				return skipBytes, fmt.Errorf("unable to read byte: %w", err)
-			}
-			skipBytes++
-			if b == 0 {
-				break
-			}
+		s, n, err := readGzipString(r, digest)
+		skipBytes += n
+		if err != nil {
+			return skipBytes, fmt.Errorf("unable to read file name: %w", err)
+		}
+		if hdr != nil {
+			hdr.Name = s
 		}
 	}
 
-	// skip comments
+	// comments
 	if flags&16 != 0 {
-		for {
-			b, err := r.ReadByte()
-			if err != nil {
-				return skipBytes, fmt.Errorf("unable to read next byte: %w", err)
-			}
-			skipBytes++
-			if b == 0 {
-				break // Read to NULL
-			}
+		s, n, err := readGzipString(r, digest)
+		skipBytes += n
+		if err != nil {
+			return skipBytes, fmt.Errorf("unable to read comment: %w", err)
+		}
+		if hdr != nil {
+			hdr.Comment = s
 		}
 	}
 
-	// skip header crc
+	// header crc, the low 16 bits of the CRC-32 of the bytes before it
 	if flags&2 != 0 {
-		discarded, err = r.Discard(2)
+		var sum [2]byte
+		n, err = io.ReadFull(r, sum[:])
+		skipBytes += n
 		if err != nil {
-			return skipBytes, fmt.Errorf("unable to skip bytes: %w", err)
+			return skipBytes, fmt.Errorf("unable to read header crc: %w", err)
+		}
+		if want := uint16(digest.Sum32()); !lenient && binary.LittleEndian.Uint16(sum[:]) != want {
+			return skipBytes, fmt.Errorf("%w: expect 0x%04x, got 0x%04x", ErrHeaderCRC, want, binary.LittleEndian.Uint16(sum[:]))
 		}
-		skipBytes += discarded
 	}
 	return skipBytes, nil
 }
 
+// readGzipString reads a NUL terminated ISO 8859-1 string and converts it to
+// UTF-8, the bytes read are written to digest.
+func readGzipString(r *bufio.Reader, digest io.Writer) (string, int, error) {
+	var runes []rune
+	for n := 1; ; n++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", n - 1, fmt.Errorf("unable to read byte: %w", err)
+		}
+		_, _ = digest.Write([]byte{b})
+		if b == 0 {
+			return string(runes), n, nil // Read to NULL
+		}
+		runes = append(runes, rune(b))
+	}
+}
+
 type gzMerger struct {
-	w           *bufio.Writer
-	zlibInBuf   unsafe.Pointer
-	zlibOutBuf  unsafe.Pointer
+	deflateMerger
+	cfg         *joinConfig
 	crc32Sum    uint32
 	checkSize32 uint32
+
+	// the header of the output, kept to be patched on Close
+	outHeader   []byte
+	wroteHeader bool
+	slot        int    // offset of the manifest slot in the header, 0 if none
+	modTime     uint32 // the newest modification time of the inputs
+
+	// the output if it's seekable and its header may be patched on Close
+	ws    io.WriteSeeker
+	start int64
 }
 
-func newGzMerger(w io.Writer) (_ *gzMerger, err error) {
-	inMemBuf := C.malloc(BufSize)
-	outMemBuf := C.malloc(BufSize)
+func newGzMerger(w io.Writer, cfg *joinConfig) (*gzMerger, error) {
+	gm := &gzMerger{cfg: cfg}
+	if err := gm.init(w); err != nil {
+		return nil, err
+	}
+	gm.bitShift = cfg.bitShift
+	if ws, ok := w.(io.WriteSeeker); ok && (cfg.embedManifest || cfg.newestModTime) {
+		if start, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			gm.ws, gm.start = ws, start
+		}
+	}
+	// the header is written along with the first input if it depends on it
+	if !cfg.firstHeader && !cfg.newestModTime {
+		if err := gm.writeHeader(nil); err != nil {
+			_ = gm.Close()
+			return nil, err
+		}
+	}
+	return gm, nil
+}
 
+// concat splices every member of the gzip input r.
+func (g *gzMerger) concat(ctx context.Context, r io.Reader) (err error) {
+	br := g.open(r)
 	defer func() {
 		if err != nil {
-			if inMemBuf != nil {
-				C.free(inMemBuf)
+			err = newConcatError(g.pos(), err)
+		}
+	}()
+
+	ra := newRandomAccess(r)
+	crc32Checker := crc32.NewIEEE()
+	trailer := make([]byte, 8)
+	var hdr gzip.Header
+
+	for member := 0; ; member++ {
+		if _, err = readGzipHeader(br, &hdr, g.cfg.lenientHeaderCRC); err != nil {
+			return fmt.Errorf("unable to skip the gzip header of member %d: %w", member, err)
+		}
+		if mtime := gzipModTime(hdr.ModTime); mtime > g.modTime {
+			g.modTime = mtime
+		}
+
+		var (
+			crc32Sum           uint32
+			uncompressedSize64 int64
+			info               *joinReadyInfo
+			ready              bool
+		)
+		if info, ready, err = g.joinReady(ra, hdr.Extra); err != nil {
+			return err
+		}
+		if ready {
+			if err = g.spliceJoinReady(ctx, br, info); err != nil {
+				return fmt.Errorf("member %d: %w", member, err)
+			}
+			crc32Sum, uncompressedSize64 = info.crc32Sum, info.size
+		} else {
+			crc32Checker.Reset()
+			if uncompressedSize64, err = g.splice(ctx, br, crc32Checker); err != nil {
+				return err
+			}
+
+			if _, err = io.ReadFull(br, trailer); err != nil {
+				return fmt.Errorf("unable to read gzip trailer of member %d: %w", member, err)
 			}
-			if outMemBuf != nil {
-				C.free(outMemBuf)
+			if g.cfg.strict {
+				if err = checkGzipTrailer(trailer, crc32Checker.Sum32(), uncompressedSize64); err != nil {
+					return fmt.Errorf("member %d: %w", member, err)
+				}
 			}
+			crc32Sum = crc32Checker.Sum32()
 		}
-	}()
 
-	if inMemBuf == nil || outMemBuf == nil {
-		errMessage := C.errMessage()
-		return nil, fmt.Errorf("unable to malloc memory for buffer: %s",
-			internal.UnsafeString((*byte)(unsafe.Pointer(errMessage)), int(C.strlen(errMessage))))
-	}
+		g.in.Checksum = IEEECrc32Combine(g.in.Checksum, crc32Sum, uncompressedSize64)
 
-	gm := &gzMerger{
-		zlibInBuf:  inMemBuf,
-		zlibOutBuf: outMemBuf,
-		w:          bufio.NewWriter(w),
-	}
-	if _, err = gm.w.Write(simpleGzipHeader); err != nil {
-		return nil, fmt.Errorf("unable to output gzip header: %w", err)
+		// another member follows unless we are at the end of input
+		if _, err = br.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				g.combine(g.in)
+				return nil
+			}
+			return fmt.Errorf("unable to read next member: %w", err)
+		}
 	}
-	return gm, nil
 }
 
-func (g *gzMerger) Close() error {
-	if g.zlibInBuf != nil {
-		C.free(g.zlibInBuf)
-		g.zlibInBuf = nil
-	}
-	if g.zlibOutBuf != nil {
-		C.free(g.zlibOutBuf)
-		g.zlibOutBuf = nil
-	}
-	return nil
+func (g *gzMerger) combine(in *InputStats) {
+	g.crc32Sum = IEEECrc32Combine(g.crc32Sum, in.Checksum, in.UncompressedBytes)
+	g.checkSize32 += uint32(in.UncompressedBytes)
 }
 
-func (g *gzMerger) concat(r io.Reader, isLastReader bool) (err error) {
-	br := bufio.NewReader(r)
-	if _, err = readGzipHeader(br); err != nil {
-		return fmt.Errorf("unable to skip the gzip header: %w", err)
-	}
+func (g *gzMerger) checksum(in *InputStats, crc32Sum, _ uint32) {
+	in.Checksum = crc32Sum
+}
 
-	var stream C.z_stream
+func (g *gzMerger) format() Format {
+	return FormatGzip
+}
 
-	if ret := C.initStream(&stream); ret != C.Z_OK {
-		return fmt.Errorf("unable to init z_stream: %d", int(ret))
+func (g *gzMerger) worker() (merger, error) {
+	wm := &gzMerger{cfg: g.cfg}
+	if err := wm.init(io.Discard); err != nil {
+		return nil, err
 	}
-	defer C.inflateEnd(&stream)
-
-	inputBuf := (*C.uchar)(g.zlibInBuf)
-	outputBuf := (*C.uchar)(g.zlibOutBuf)
-
-	crc32Checker := crc32.NewIEEE()
-	uncompressedSize64 := int64(0)
+	wm.bitShift = g.bitShift
+	return wm, nil
+}
 
-	readSize, err := readToBuf(&stream, br, inputBuf)
-	if err != nil {
+func (g *gzMerger) finish() error {
+	if err := g.deflateMerger.finish(); err != nil {
 		return err
 	}
-
-	stream.next_out = outputBuf
-	stream.avail_out = BufSize
-
-	lastBlock := (*(*byte)(inputBuf))&1 != 0
-	if lastBlock && !isLastReader {
-		*(*byte)(inputBuf) = *(*byte)(inputBuf) & (^byte(1))
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[:4], g.crc32Sum)
+	binary.LittleEndian.PutUint32(trailer[4:], g.checkSize32)
+	if _, err := g.w.Write(trailer); err != nil {
+		return fmt.Errorf("unable to output gzip trailer: %w", err)
 	}
+	if err := g.w.Flush(); err != nil {
+		return fmt.Errorf("unable to flush write buffer: %w", err)
+	}
+	return nil
+}
 
-	for {
-		if stream.avail_in == 0 && stream.avail_out > 0 {
-			if _, err = g.w.Write(unsafe.Slice((*byte)(inputBuf), readSize)); err != nil {
-				return fmt.Errorf("unable to write: %w", err)
-			}
-			if readSize, err = readToBuf(&stream, br, inputBuf); err != nil {
-				return err
-			}
-		}
+var errReaderClosed = errors.New("dfjoin: reader is closed")
 
-		if stream.avail_out < BufSize {
-			_, _ = crc32Checker.Write(unsafe.Slice((*byte)(outputBuf), int(BufSize-stream.avail_out)))
-			stream.next_out = outputBuf
-			stream.avail_out = BufSize
-		}
+// GzipReader decompresses a gzip stream with zlib's inflate, it has the method
+// set of compress/gzip.Reader. Header holds the header of the member being read.
+type GzipReader struct {
+	gzip.Header
+	inflater
+	multistream bool
+	lenient     bool // whether the header CRC is left unchecked
+	crc32Sum    uint32
+	checkSize32 uint32
+	member      int // index of the member being read
+	err         error
+}
 
-		// dfjoin._Ctype_uint, *dfjoin._Ctype_uchar
-		//fmt.Printf("%T, %T\n", stream.avail_in, stream.next_in)
-		ret := C.inflate(&stream, C.Z_BLOCK)
+// NewGzipReader returns a GzipReader reading r, whose first header is read
+// before it returns, or io.EOF if r is empty. Every member of r is read unless
+// Multistream(false) is called. As with compress/gzip, r is read directly if
+// it's a *bufio.Reader, so that it isn't read past the member read.
+func NewGzipReader(r io.Reader) (*GzipReader, error) {
+	return NewGzipReaderWith(r)
+}
 
-		if errCode, ok := inflateErrors[int(ret)]; ok {
-			return fmt.Errorf("unable to inflate, error code: %d(%s)", int(ret), errCode)
-		}
+// ReaderOption configures a GzipReader.
+type ReaderOption func(*readerConfig)
 
-		uncompressedSize64 += int64(BufSize - stream.avail_out)
+type readerConfig struct {
+	lenientHeaderCRC bool
+}
 
-		if stream.data_type&C.int(128) != 0 {
-			if lastBlock {
-				break
-			}
-			pos := stream.data_type & 7 // 00000111
-			if pos != 0 {
-				pos = 0x100 >> pos
-				preByte := unsafe.Slice((*byte)(inputBuf), readSize)[readSize-int(stream.avail_in)-1]
-				lastBlock = byte(pos)&preByte != 0
-				if lastBlock && !isLastReader {
-					unsafe.Slice((*byte)(inputBuf), readSize)[readSize-int(stream.avail_in)-1] &= ^byte(pos)
-				}
-			} else {
-				if stream.avail_in == 0 {
-					if _, err = g.w.Write(unsafe.Slice((*byte)(inputBuf), readSize)); err != nil {
-						return fmt.Errorf("unable to output: %w", err)
-					}
-
-					if readSize, err = readToBuf(&stream, br, inputBuf); err != nil {
-						return
-					}
-				}
-				lastBlock = (*(*byte)(stream.next_in))&1 != 0
-				if lastBlock && !isLastReader {
-					*(*byte)(stream.next_in) &= ^byte(1)
-				}
-			}
-		}
+// WithReaderLenientHeaderCRC makes a GzipReader skip the header CRC of the
+// members without checking it, as WithLenientHeaderCRC does for a Joiner.
+func WithReaderLenientHeaderCRC() ReaderOption {
+	return func(c *readerConfig) {
+		c.lenientHeaderCRC = true
 	}
+}
 
-	if stream.avail_out < BufSize {
-		_, _ = crc32Checker.Write(unsafe.Slice((*byte)(outputBuf), int(BufSize-stream.avail_out)))
+// NewGzipReaderWith is like NewGzipReader but takes options.
+func NewGzipReaderWith(r io.Reader, opts ...ReaderOption) (*GzipReader, error) {
+	var cfg readerConfig
+	for _, opt := range opts {
+		opt(&cfg)
 	}
-
-	pos := stream.data_type & 7
-	if _, err = g.w.Write(unsafe.Slice((*byte)(inputBuf), readSize-int(stream.avail_in)-1)); err != nil {
-		err = fmt.Errorf("unable to output: %w", err)
-		return
+	gz := &GzipReader{lenient: cfg.lenientHeaderCRC}
+	if err := gz.init(r); err != nil {
+		return nil, err
 	}
-
-	lastByte := unsafe.Slice((*byte)(inputBuf), readSize)[readSize-int(stream.avail_in)-1]
-
-	if pos == 0 || isLastReader {
-		if err = g.w.WriteByte(lastByte); err != nil {
-			err = fmt.Errorf("unable to output last byte: %w", err)
-			return
-		}
-	} else {
-		lastByte &= byte((int(0x100) >> pos) - 1)
-		if pos&1 != 0 {
-			// odd
-			if err = g.w.WriteByte(lastByte); err != nil {
-				err = fmt.Errorf("unable to output last byte: %w", err)
-				return
-			}
-			if pos == 1 {
-				if err = g.w.WriteByte(0); err != nil {
-					err = fmt.Errorf("unable to output last byte: %w", err)
-					return
-				}
-			}
-			if _, err = g.w.Write([]byte{0, 0, 255, 255}); err != nil {
-				err = fmt.Errorf("unable to output empty block: %w", err)
-				return
-			}
-		} else {
-			// even
-			switch pos {
-			case 6:
-				if err = g.w.WriteByte(lastByte | 8); err != nil {
-					err = fmt.Errorf("unable to output last byte: %w", err)
-					return
-				}
-				lastByte = 0
-				fallthrough
-			case 4:
-				if err = g.w.WriteByte(lastByte | 0x20); err != nil {
-					err = fmt.Errorf("unable to output last byte: %w", err)
-					return
-				}
-				lastByte = 0
-				fallthrough
-			case 2:
-				if err = g.w.WriteByte(lastByte | 0x80); err != nil {
-					err = fmt.Errorf("unable to output last byte: %w", err)
-					return
-				}
-				if err = g.w.WriteByte(0); err != nil {
-					err = fmt.Errorf("unable to output last byte: %w", err)
-					return
-				}
-			}
-		}
+	if err := gz.start(); err != nil {
+		_ = gz.Close()
+		return nil, err
 	}
+	return gz, nil
+}
 
-	g.crc32Sum = IEEECrc32Combine(g.crc32Sum, crc32Checker.Sum32(), uncompressedSize64)
-	g.checkSize32 += uint32(uncompressedSize64)
-
-	if isLastReader {
-		trailer := make([]byte, 8)
-		binary.LittleEndian.PutUint32(trailer[:4], g.crc32Sum)
-		binary.LittleEndian.PutUint32(trailer[4:], g.checkSize32)
-		if _, err := g.w.Write(trailer); err != nil {
-			return fmt.Errorf("unable to output gzip trailer: %w", err)
-		}
-		if err = g.w.Flush(); err != nil {
-			return fmt.Errorf("unable to flush write buffer: %w", err)
+// Reset discards the state of the GzipReader and makes it read r, as a new
+// GzipReader would, reusing the buffers and the zlib state unless it has been
+// closed.
+func (g *GzipReader) Reset(r io.Reader) error {
+	if g.stream == nil {
+		if err := g.init(r); err != nil {
+			g.err = err
+			return err
 		}
+	} else if err := g.reset(r); err != nil {
+		g.err = err
+		return err
 	}
-
-	return nil
+	return g.start()
 }
 
-func readToBuf(stream *C.z_stream, r io.Reader, buf *C.uchar) (int, error) {
-	readSize, err := io.ReadFull(r, unsafe.Slice((*byte)(buf), BufSize))
-	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
-		return readSize, fmt.Errorf("unable to read to buf: %w", err)
+func (g *GzipReader) start() error {
+	g.multistream = true
+	g.crc32Sum, g.checkSize32, g.member, g.err = 0, 0, 0, nil
+	n, err := readGzipHeader(g.br, &g.Header, g.lenient)
+	g.n += int64(n)
+	if n == 0 && errors.Is(err, io.EOF) {
+		// nothing at all, as compress/gzip
+		g.err = io.EOF
+		return g.err
 	}
-	if readSize == 0 {
-		return readSize, fmt.Errorf("unable to read deflate data: %w", io.ErrUnexpectedEOF)
+	if err != nil {
+		g.err = g.concatError(0, fmt.Errorf("unable to read gzip header data: n = %d, %w", n, err))
+		return g.err
 	}
-	//fmt.Println("read compressed bytes: ", readSize)
-	stream.avail_in = C.uint(readSize)
-	stream.next_in = buf
-	return readSize, nil
+	return nil
 }
 
-func (g *gzReader) Read(p []byte) (n int, err error) {
-	defer func() {
-		if n > 0 {
-			g.crc32Sum = crc32.Update(g.crc32Sum, crc32.IEEETable, p[:n])
-			g.checkSize32 += uint32(n)
-		}
-		if errors.Is(err, io.EOF) {
-			unRead := io.MultiReader(
-				bytes.NewReader(unsafe.Slice((*byte)(g.stream.next_in), int(g.stream.avail_in))),
-				g.br,
-			)
-			trailer := make([]byte, 8)
-			if _, ex := io.ReadFull(unRead, trailer); ex != nil {
-				err = fmt.Errorf("unable to read gzip trailer: %w", ex)
-				return
-			}
-
-			trailerCrc32 := binary.LittleEndian.Uint32(trailer[:4])
-			if g.crc32Sum != trailerCrc32 {
-				err = fmt.Errorf("%w: expect 0x%x, got 0x%x", ErrChecksum, g.crc32Sum, trailerCrc32)
-				return
-			}
-			checkSize := binary.LittleEndian.Uint32(trailer[4:])
-			if g.checkSize32 != checkSize {
-				err = fmt.Errorf("%w: expect %d, got %d", ErrCheckSize, g.checkSize32, checkSize)
-				return
-			}
-		}
-	}()
+// Multistream controls whether the reader reads every member of the gzip stream,
+// which it does by default, or stops with io.EOF at the end of the first one.
+// The underlying reader is then left positioned right after the member if it's
+// a *bufio.Reader, which is read directly, or an io.Seeker, which is seeked
+// back by the bytes read ahead, so that a Reset on it reads the next member.
+func (g *GzipReader) Multistream(ok bool) {
+	g.multistream = ok
+}
 
-	for n < len(p) {
-		if g.offset > 0 && g.offset >= int(BufSize-g.stream.avail_out) {
-			g.offset = 0
-			g.stream.next_out = g.outputBuf
-			g.stream.avail_out = BufSize
+func (g *GzipReader) Read(p []byte) (n int, err error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	for n == 0 && len(p) > 0 {
+		n, err = g.read(p)
+		g.crc32Sum = crc32.Update(g.crc32Sum, crc32.IEEETable, p[:n])
+		g.checkSize32 += uint32(n)
+		if err != io.EOF {
+			break
 		}
-
-		for !g.inflateEnd && g.offset >= int(BufSize-g.stream.avail_out) {
-			if err = g.inflate(); err != nil {
-				return 0, fmt.Errorf("inflate: %w", err)
-			}
+		if err = g.nextMember(); err != nil {
+			break
 		}
-
-		if g.offset >= int(BufSize-g.stream.avail_out) {
-			if n == 0 {
-				return 0, io.EOF
-			}
-			return n, nil
+	}
+	if err != nil {
+		if err != io.EOF {
+			err = g.concatError(g.member, err)
 		}
-
-		uncompressed := unsafe.Slice((*byte)(g.outputBuf), int(BufSize-g.stream.avail_out))
-		copied := copy(p[n:], uncompressed[g.offset:])
-		n += copied
-		g.offset += copied
+		g.err = err
 	}
-	return
+	return n, err
 }
 
-var _ io.ReadCloser = (*gzReader)(nil)
-
-func NewGzipReader(r io.Reader) (io.ReadCloser, error) {
-	var stream C.z_stream
-
-	if ret := C.initStream(&stream); ret != C.Z_OK {
-		return nil, fmt.Errorf("unable to init z_stream: %d", int(ret))
+// nextMember checks the trailer of the member which has been read, and reads
+// the header of the next one. It returns io.EOF at the end of the gzip stream.
+func (g *GzipReader) nextMember() error {
+	if err := g.sync(); err != nil {
+		return err
 	}
-
-	memIn := C.malloc(BufSize)
-	memOut := C.malloc(BufSize)
-
-	if memIn == nil || memOut == nil {
-		errMessage := C.errMessage()
-		return nil, fmt.Errorf("unable to malloc buffer memory: %s",
-			internal.UnsafeString((*byte)(unsafe.Pointer(errMessage)), int(C.strlen(errMessage))))
+	trailer := make([]byte, 8)
+	n, err := io.ReadFull(g.br, trailer)
+	g.n += int64(n)
+	if err != nil {
+		return fmt.Errorf("unable to read gzip trailer: %w", err)
+	}
+	trailerCrc32 := binary.LittleEndian.Uint32(trailer[:4])
+	if g.crc32Sum != trailerCrc32 {
+		return fmt.Errorf("%w: expect 0x%x, got 0x%x", ErrChecksum, g.crc32Sum, trailerCrc32)
+	}
+	checkSize := binary.LittleEndian.Uint32(trailer[4:])
+	if g.checkSize32 != checkSize {
+		return fmt.Errorf("%w: expect %d, got %d", ErrCheckSize, g.checkSize32, checkSize)
 	}
 
-	br := bufio.NewReader(r)
-
-	stream.avail_out = BufSize
-	stream.next_out = (*C.uchar)(memOut)
-
-	gz := &gzReader{
-		inflater: inflater{
-			stream:    &stream,
-			inputBuf:  (*C.uchar)(memIn),
-			outputBuf: (*C.uchar)(memOut),
-			br:        br,
-		},
+	if !g.multistream {
+		if err = g.unread(); err != nil {
+			return err
+		}
+		return io.EOF
 	}
-	if n, err := gz.readHeader(); err != nil {
-		_ = gz.Close()
-		return nil, fmt.Errorf("unable to read gzip header data: n = %d, %w", n, err)
+	if _, err = g.br.Peek(1); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return fmt.Errorf("unable to read next member: %w", err)
 	}
+	g.member++
+	n, err = readGzipHeader(g.br, &g.Header, g.lenient)
+	g.n += int64(n)
+	if err != nil {
+		return fmt.Errorf("unable to read gzip header of next member: %w", err)
+	}
+	g.crc32Sum, g.checkSize32 = 0, 0
+	return g.restart()
+}
 
-	return gz, nil
+// Close frees the buffers and the zlib state, it doesn't close the underlying
+// io.Reader.
+func (g *GzipReader) Close() error {
+	if g.err == nil || g.err == io.EOF {
+		g.err = errReaderClosed
+	}
+	return g.inflater.Close()
 }
 
 func CGOTest() {
@@ -1,12 +1,16 @@
 package dfjoin
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
 	crand "crypto/rand"
 	_ "embed"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"math"
 	"math/rand"
@@ -162,6 +166,121 @@ func TestNewGzipReader(t *testing.T) {
 	assert.Equal(t, size/len(text4Test), readNum)
 }
 
+func TestGzipReaderMultistream(t *testing.T) {
+	inputs := genTestInputs(5)
+	var members []byte
+	for i, input := range inputs {
+		out := new(bytes.Buffer)
+		gw := gzip.NewWriter(out)
+		gw.Name = fmt.Sprintf("member%d.txt", i)
+		gw.ModTime = time.Unix(int64(1600000000+i), 0)
+		_, _ = gw.Write(input)
+		_ = gw.Close()
+		members = append(members, out.Bytes()...)
+	}
+
+	gr, err := NewGzipReader(bytes.NewReader(members))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "member0.txt", gr.Name)
+	assert.Equal(t, time.Unix(1600000000, 0), gr.ModTime)
+	data, err := io.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Join(inputs, nil), data)
+	assert.Equal(t, "member4.txt", gr.Name)
+
+	// Reset before and after Close, reading one member at a time
+	for _, closeFirst := range []bool{false, true} {
+		if closeFirst {
+			assert.NoError(t, gr.Close())
+			_, err = gr.Read(make([]byte, 1))
+			assert.Error(t, err)
+		}
+		assert.NoError(t, gr.Reset(bytes.NewReader(members)))
+		gr.Multistream(false)
+		data, err = io.ReadAll(gr)
+		assert.NoError(t, err)
+		assert.Equal(t, inputs[0], data)
+	}
+	assert.NoError(t, gr.Close())
+
+	// a corrupt trailer in the last member, and trailing garbage
+	corrupt := append([]byte(nil), members...)
+	corrupt[len(corrupt)-5] ^= 0xff
+	for _, data := range [][]byte{corrupt, append(members, 0, 0, 0)} {
+		gr, err = NewGzipReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(gr)
+		assert.Error(t, err)
+		_ = gr.Close()
+	}
+}
+
+func TestGzipReaderMemberLoop(t *testing.T) {
+	inputs := genTestInputs(4)
+	var members []byte
+	for _, input := range inputs {
+		members = append(members, gzCompress(input)...)
+	}
+
+	// the loop reading one member at a time with compress/gzip
+	for _, r := range []io.Reader{bufio.NewReader(bytes.NewReader(members)), bytes.NewReader(members)} {
+		gr, err := NewGzipReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][]byte
+		for {
+			gr.Multistream(false)
+			data, err := io.ReadAll(gr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, data)
+			if err = gr.Reset(r); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.Equal(t, inputs, got)
+		assert.NoError(t, gr.Close())
+	}
+
+	_, err := NewGzipReader(bytes.NewReader(nil))
+	assert.Equal(t, io.EOF, err)
+}
+
+func BenchmarkGzipReaderReset(b *testing.B) {
+	data := gzCompress([]byte("a small gzip record read in a hot path\n"))
+	b.Run("new", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			gr, err := NewGzipReader(bytes.NewReader(data))
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, gr)
+			_ = gr.Close()
+		}
+	})
+	b.Run("reset", func(b *testing.B) {
+		gr, err := NewGzipReader(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer gr.Close()
+		for i := 0; i < b.N; i++ {
+			if err = gr.Reset(bytes.NewReader(data)); err != nil {
+				b.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, gr)
+		}
+	})
+}
+
 func TestCorruptGzipStream(t *testing.T) {
 	out := new(bytes.Buffer)
 	gw := gzip.NewWriter(out)
@@ -397,3 +516,223 @@ func BenchmarkConcatGzip(b *testing.B) {
 func TestCGOTest(t *testing.T) {
 	CGOTest()
 }
+
+func gzCompress(p []byte) []byte {
+	out := new(bytes.Buffer)
+	gw := gzip.NewWriter(out)
+	if _, err := gw.Write(p); err != nil {
+		panic(err)
+	}
+	if err := gw.Close(); err != nil {
+		panic(err)
+	}
+	return out.Bytes()
+}
+
+// readSingleMember decompresses the first member of a gzip stream and makes
+// sure nothing follows it.
+func readSingleMember(t *testing.T, r io.Reader) []byte {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gr.Multistream(false)
+	plain, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = gr.Reset(r); !errors.Is(err, io.EOF) {
+		t.Fatalf("expect a single member, got %v", err)
+	}
+	return plain
+}
+
+func TestConcatMultiMemberGzip(t *testing.T) {
+	inputs := genTestInputs(6)
+
+	multi1 := append(gzCompress(inputs[0]), gzCompress(inputs[1])...)
+	multi2 := append(append(gzCompress(inputs[3]), gzCompress(inputs[4])...), gzCompress(inputs[5])...)
+
+	joined := new(bytes.Buffer)
+	if err := ConcatGzip(joined, bytes.NewReader(multi1), bytes.NewReader(gzCompress(inputs[2])),
+		bytes.NewReader(multi2)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Join(inputs, nil), readSingleMember(t, joined))
+}
+
+func TestNormalizeGzip(t *testing.T) {
+	inputs := genTestInputs(10)
+
+	var multi []byte
+	for _, input := range inputs {
+		multi = append(multi, gzCompress(input)...)
+	}
+
+	normalized := new(bytes.Buffer)
+	if err := NormalizeGzip(normalized, bytes.NewReader(multi)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Join(inputs, nil), readSingleMember(t, normalized))
+
+	t.Run("trailing-garbage", func(t *testing.T) {
+		err := NormalizeGzip(io.Discard, bytes.NewReader(append(multi, 0)))
+		assert.Error(t, err)
+	})
+}
+
+func TestReadGzipHeader(t *testing.T) {
+	out := new(bytes.Buffer)
+	gw := gzip.NewWriter(out)
+	gw.Header = gzip.Header{
+		Name:    "naïve.txt",
+		Comment: "a comment",
+		Extra:   []byte("AB\x02\x00hi"),
+		ModTime: time.Unix(1700000000, 0),
+		OS:      3,
+	}
+	_, _ = gw.Write(text4Test)
+	_ = gw.Close()
+
+	var hdr gzip.Header
+	n, err := readGzipHeader(bufio.NewReader(bytes.NewReader(out.Bytes())), &hdr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the name is ISO 8859-1 encoded, both strings are NUL terminated
+	assert.Equal(t, 10+2+6+10+10, n)
+	assert.Equal(t, gw.Header.Name, hdr.Name)
+	assert.Equal(t, gw.Header.Comment, hdr.Comment)
+	assert.Equal(t, gw.Header.Extra, hdr.Extra)
+	assert.True(t, gw.Header.ModTime.Equal(hdr.ModTime))
+	assert.Equal(t, gw.Header.OS, hdr.OS)
+}
+
+func TestGzipHeaderCRC(t *testing.T) {
+	joined := new(bytes.Buffer)
+	err := ConcatGzipWith(joined, []io.Reader{bytes.NewReader(gzCompress(text4Test))},
+		WithHeader(gzip.Header{Name: "text.txt"}), WithHeaderCRC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := joined.Bytes()
+	n, err := readGzipHeader(bufio.NewReader(bytes.NewReader(valid)), nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 10+9+2, n)
+
+	// a flipped bit in the name
+	corrupt := append([]byte(nil), valid...)
+	corrupt[12] ^= 1
+
+	_, err = NewGzipReader(bytes.NewReader(corrupt))
+	assert.ErrorIs(t, err, ErrHeaderCRC)
+	assert.ErrorIs(t, err, ErrHeader)
+	err = ConcatGzip(io.Discard, bytes.NewReader(corrupt))
+	assert.ErrorIs(t, err, ErrHeaderCRC)
+
+	gr, err := NewGzipReaderWith(bytes.NewReader(corrupt), WithReaderLenientHeaderCRC())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "teyt.txt", gr.Name)
+		data, err := io.ReadAll(gr)
+		assert.NoError(t, err)
+		assert.Equal(t, text4Test, data)
+		assert.NoError(t, gr.Reset(bytes.NewReader(corrupt)))
+	}
+	out := new(bytes.Buffer)
+	assert.NoError(t, ConcatGzipWith(out, []io.Reader{bytes.NewReader(corrupt)}, WithLenientHeaderCRC()))
+	assert.Equal(t, text4Test, readSingleMember(t, out))
+}
+
+// flushedGzip compresses p with Go's gzip, flushed before it's closed so that it
+// ends with a sync flush and an empty fixed block.
+func flushedGzip(p []byte) []byte {
+	out := new(bytes.Buffer)
+	gw := gzip.NewWriter(out)
+	_, _ = gw.Write(p)
+	_ = gw.Flush()
+	_ = gw.Close()
+	return out.Bytes()
+}
+
+// storedTailGzip compresses p into a gzip member ending with the empty stored
+// block last, whose first byte may hold the end of the previous block.
+func storedTailGzip(p []byte, last []byte) []byte {
+	out := bytes.NewBuffer(append([]byte(nil), simpleGzipHeader...))
+	fw, _ := flate.NewWriter(out, flate.DefaultCompression)
+	_, _ = fw.Write(p)
+	_ = fw.Flush()
+	out.Write(last)
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(p))
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(len(p)))
+	out.Write(trailer[:])
+	return out.Bytes()
+}
+
+func TestEmptyLastBlock(t *testing.T) {
+	inputs := genTestInputs(12)
+	compressed := make([][]byte, len(inputs))
+	for i, input := range inputs {
+		switch i % 4 {
+		case 0:
+			compressed[i] = flushedGzip(input)
+		case 1:
+			compressed[i] = storedTailGzip(input, []byte{1, 0, 0, 0xff, 0xff})
+		case 2:
+			// an empty fixed block, and the stored block at bit 2
+			compressed[i] = storedTailGzip(input, []byte{2, 4, 0, 0, 0xff, 0xff})
+		default:
+			compressed[i] = gzCompress(input)
+		}
+		assert.Equal(t, input, readSingleMember(t, bytes.NewReader(compressed[i])))
+	}
+
+	readers := make([]io.Reader, len(compressed))
+	for i, c := range compressed {
+		readers[i] = bytes.NewReader(c)
+	}
+	var stats JoinStats
+	joined := new(bytes.Buffer)
+	if err := ConcatGzipWith(joined, readers, WithStats(&stats)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Join(inputs, nil), readSingleMember(t, bytes.NewReader(joined.Bytes())))
+	for i, in := range stats.Inputs {
+		assert.Equal(t, int64(len(compressed[i])), in.CompressedBytes)
+		assert.Equal(t, int64(len(inputs[i])), in.UncompressedBytes)
+		assert.NotZero(t, in.Blocks, "input %d", i)
+	}
+}
+
+func TestStoredDataEndingAsEmptyBlock(t *testing.T) {
+	// the data of the last stored block end with the bytes of an empty stored
+	// block, which isn't where the last block starts
+	input := append(bytes.Repeat([]byte("abc"), 100), "\x05\x00\x00\xff\xff"...)
+	compressed := new(bytes.Buffer)
+	gw, err := NewGzipWriter(compressed, NoCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gw.Write(input)
+	assert.NoError(t, err)
+	assert.NoError(t, gw.Close())
+
+	joined := new(bytes.Buffer)
+	inputs := []io.Reader{bytes.NewReader(compressed.Bytes()), bytes.NewReader(compressed.Bytes())}
+	if err = ConcatGzipWith(joined, inputs); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, append(input, input...), readSingleMember(t, bytes.NewReader(joined.Bytes())))
+}
+
+func TestFlushedMembers(t *testing.T) {
+	members := append(flushedGzip([]byte("first ")), flushedGzip([]byte("second"))...)
+
+	var stats JoinStats
+	joined := new(bytes.Buffer)
+	if err := ConcatGzipWith(joined, []io.Reader{bytes.NewReader(members)}, WithStats(&stats)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte("first second"), readSingleMember(t, bytes.NewReader(joined.Bytes())))
+	assert.Equal(t, int64(12), stats.Inputs[0].UncompressedBytes)
+}
@@ -0,0 +1,255 @@
+package dfjoin
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// WithHeader makes a gzip Joiner write the name, the comment, the extra field,
+// the modification time and the OS of hdr in the header of the output, rather
+// than leaving them empty with OS 255 (unknown). It overrides WithFirstHeader.
+func WithHeader(hdr gzip.Header) Option {
+	return func(c *joinConfig) {
+		c.header, c.firstHeader = &hdr, false
+	}
+}
+
+// WithFirstHeader makes a gzip Joiner copy the name, the comment, the extra
+// field, the modification time and the OS of the first input to the header of
+// the output, leaving out the subfields of JoinReadyWriter and
+// WithEmbeddedManifest. The header is written when the first input is appended,
+// which is read back from its start by seeking if it's an io.Seeker. It
+// overrides WithHeader.
+func WithFirstHeader() Option {
+	return func(c *joinConfig) {
+		c.header, c.firstHeader = nil, true
+	}
+}
+
+// WithNewestModTime makes a gzip Joiner set the modification time of the output
+// to the newest one of the inputs. ConcatGzipWith reads the header of every
+// input first. A Joiner, which doesn't know its inputs ahead of time, writes the
+// time of the first input and patches it on Close if the output is an
+// io.WriteSeeker, both patch the time of members beyond the first ones of the
+// inputs that way.
+func WithNewestModTime() Option {
+	return func(c *joinConfig) {
+		c.newestModTime = true
+	}
+}
+
+// WithHeaderCRC makes a gzip Joiner protect the header of the output with a
+// CRC-16, the FHCRC flag.
+func WithHeaderCRC() Option {
+	return func(c *joinConfig) {
+		c.headerCRC = true
+	}
+}
+
+// WithLenientHeaderCRC makes a gzip Joiner skip the header CRC of the inputs
+// without checking it, for producers known to write wrong ones. By default a
+// mismatch fails with ErrHeaderCRC. WithReaderLenientHeaderCRC does the same
+// for a GzipReader.
+func WithLenientHeaderCRC() Option {
+	return func(c *joinConfig) {
+		c.lenientHeaderCRC = true
+	}
+}
+
+// gzipModTime returns t as the MTIME of a gzip header, 0 if it's unset.
+func gzipModTime(t time.Time) uint32 {
+	if t.After(time.Unix(0, 0)) {
+		return uint32(t.Unix())
+	}
+	return 0
+}
+
+// peekHeader calls read with a reader of the start of r, and returns r rewound
+// to where it was, by seeking or else by replaying the bytes read. Plain inputs
+// aren't read.
+func peekHeader(r io.Reader, read func(br *bufio.Reader)) (io.Reader, error) {
+	if _, ok := r.(*PlainInput); ok {
+		return r, nil
+	}
+	var replay bytes.Buffer
+	src := io.TeeReader(r, &replay)
+	s, seekable := r.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = s.Seek(0, io.SeekCurrent); err == nil {
+			src = r
+		} else {
+			seekable = false
+		}
+	}
+
+	read(bufio.NewReader(src))
+	if !seekable {
+		return io.MultiReader(&replay, r), nil
+	}
+	if _, err := s.Seek(start, io.SeekStart); err != nil {
+		return r, fmt.Errorf("unable to seek back the input: %w", err)
+	}
+	return r, nil
+}
+
+// peekGzipHeader reads the gzip header at the start of r, and returns r rewound,
+// see peekHeader. The header is nil if r doesn't start with a valid one, which
+// is left to the splice to report.
+func peekGzipHeader(r io.Reader, lenient bool) (*gzip.Header, io.Reader, error) {
+	var hdr *gzip.Header
+	r, err := peekHeader(r, func(br *bufio.Reader) {
+		hdr = &gzip.Header{}
+		if _, err := readGzipHeader(br, hdr, lenient); err != nil {
+			hdr = nil
+		}
+	})
+	return hdr, r, err
+}
+
+// newestModTime returns the newest modification time of the headers of the
+// inputs, and the inputs rewound.
+func newestModTime(inputs []io.Reader, lenient bool) ([]io.Reader, uint32, error) {
+	rewound := make([]io.Reader, len(inputs))
+	var mtime uint32
+	for i, r := range inputs {
+		hdr, r, err := peekGzipHeader(r, lenient)
+		if err != nil {
+			return nil, 0, fmt.Errorf("input %d: %w", i, err)
+		}
+		if hdr != nil && gzipModTime(hdr.ModTime) > mtime {
+			mtime = gzipModTime(hdr.ModTime)
+		}
+		rewound[i] = r
+	}
+	return rewound, mtime, nil
+}
+
+// dropOwnSubfields returns the extra field without the subfields written by
+// JoinReadyWriter and WithEmbeddedManifest, which would be stale in another
+// member. It's returned as is if it isn't made of subfields.
+func dropOwnSubfields(extra []byte) []byte {
+	var kept []byte
+	for rest := extra; len(rest) > 0; {
+		if len(rest) < 4 {
+			return extra
+		}
+		n := 4 + int(binary.LittleEndian.Uint16(rest[2:]))
+		if len(rest) < n {
+			return extra
+		}
+		own := rest[0] == joinReadySI1 && rest[1] == joinReadySI2 || rest[0] == manifestSI1 && rest[1] == manifestSI2
+		if !own {
+			kept = append(kept, rest[:n]...)
+		}
+		rest = rest[n:]
+	}
+	return kept
+}
+
+// header writes the header deferred by WithFirstHeader or WithNewestModTime with
+// the header of r, the first input if it isn't nil, and returns r rewound.
+func (g *gzMerger) header(r io.Reader) (io.Reader, error) {
+	if g.wroteHeader {
+		return r, nil
+	}
+	var first *gzip.Header
+	if r != nil {
+		var err error
+		if first, r, err = peekGzipHeader(r, g.cfg.lenientHeaderCRC); err != nil {
+			return r, fmt.Errorf("unable to read the header of the first input: %w", err)
+		}
+	}
+	return r, g.writeHeader(first)
+}
+
+// writeHeader writes the header of the output, with the fields of first, the
+// header of the first input, if WithFirstHeader or WithNewestModTime is set.
+func (g *gzMerger) writeHeader(first *gzip.Header) error {
+	g.wroteHeader = true
+	hdr := gzip.Header{OS: 255}
+	if g.cfg.header != nil {
+		hdr = *g.cfg.header
+	} else if g.cfg.firstHeader && first != nil {
+		hdr = *first
+		hdr.Extra = dropOwnSubfields(first.Extra)
+	}
+	if g.cfg.newestModTime {
+		mtime := g.cfg.modTime
+		if first != nil && gzipModTime(first.ModTime) > mtime {
+			mtime = gzipModTime(first.ModTime)
+		}
+		hdr.ModTime = time.Time{}
+		if mtime > 0 {
+			hdr.ModTime = time.Unix(int64(mtime), 0)
+		}
+	}
+
+	// the manifest slot is the last subfield
+	if g.ws != nil && g.cfg.embedManifest && len(hdr.Extra)+4+g.cfg.manifestReserve <= 0xffff {
+		g.slot = len(simpleGzipHeader) + 2 + len(hdr.Extra) + 4
+		hdr.Extra = appendSubfield(hdr.Extra[:len(hdr.Extra):len(hdr.Extra)], manifestSI1, manifestSI2, make([]byte, g.cfg.manifestReserve))
+	}
+
+	header, err := appendGzipHeader(nil, &hdr, DefaultCompression)
+	if err != nil {
+		return fmt.Errorf("unable to output gzip header: %w", err)
+	}
+	if g.cfg.headerCRC {
+		header[3] |= 2
+		header = append(header, 0, 0)
+		g.sumHeader(header)
+	}
+	g.outHeader = header
+	if _, err = g.w.Write(header); err != nil {
+		return fmt.Errorf("unable to output gzip header: %w", err)
+	}
+	return nil
+}
+
+// sumHeader fills in the CRC-16 ending the header.
+func (g *gzMerger) sumHeader(header []byte) {
+	binary.LittleEndian.PutUint16(header[len(header)-2:], uint16(crc32.ChecksumIEEE(header[:len(header)-2])))
+}
+
+// patchHeader overwrites the header of the output at off with data, along with
+// its CRC-16 if it has one.
+func (g *gzMerger) patchHeader(off int, data []byte) error {
+	end, err := g.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	copy(g.outHeader[off:], data)
+	patch := g.outHeader[off : off+len(data)]
+	if g.cfg.headerCRC {
+		g.sumHeader(g.outHeader)
+		patch = g.outHeader[off:]
+	}
+	if _, err = g.ws.Seek(g.start+int64(off), io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = g.ws.Write(patch); err != nil {
+		return fmt.Errorf("unable to patch the gzip header: %w", err)
+	}
+	_, err = g.ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// patchModTime sets the modification time of the output to the newest one of
+// the inputs spliced, if it's newer than the one written and the output is
+// seekable.
+func (g *gzMerger) patchModTime() error {
+	if !g.cfg.newestModTime || g.ws == nil || g.modTime <= binary.LittleEndian.Uint32(g.outHeader[4:]) {
+		return nil
+	}
+	var mtime [4]byte
+	binary.LittleEndian.PutUint32(mtime[:], g.modTime)
+	return g.patchHeader(4, mtime[:])
+}
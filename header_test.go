@@ -0,0 +1,154 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzWithHeader(t *testing.T, hdr gzip.Header, p []byte) []byte {
+	out := new(bytes.Buffer)
+	gw := gzip.NewWriter(out)
+	gw.Header = hdr
+	if _, err := gw.Write(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// readJoined checks the header CRC, if any, and the data of the gzip stream.
+func readJoined(t *testing.T, joined []byte, want []byte) gzip.Header {
+	gr, err := gzip.NewReader(bytes.NewReader(joined))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, want, data)
+	return gr.Header
+}
+
+func TestHeader(t *testing.T) {
+	older := time.Unix(1600000000, 0)
+	newer := time.Unix(1700000000, 0)
+	first := gzip.Header{Name: "access.log", Comment: "día 1", ModTime: older, OS: 3,
+		Extra: appendSubfield(appendSubfield(nil, 'A', 'b', []byte("kept")), joinReadySI1, joinReadySI2, make([]byte, joinReadyLen))}
+	files := [][]byte{
+		gzWithHeader(t, first, []byte("first\n")),
+		gzWithHeader(t, gzip.Header{Name: "other.log", ModTime: newer, OS: 0}, []byte("second\n")),
+	}
+	want := []byte("first\nsecond\n")
+	readers := func(seekable bool) []io.Reader {
+		rs := make([]io.Reader, len(files))
+		for i, data := range files {
+			rs[i] = bytes.NewReader(data)
+			if !seekable {
+				rs[i] = struct{ io.Reader }{rs[i]}
+			}
+		}
+		return rs
+	}
+
+	for _, seekable := range []bool{true, false} {
+		joined := new(bytes.Buffer)
+		if err := ConcatGzipWith(joined, readers(seekable), WithFirstHeader(), WithHeaderCRC()); err != nil {
+			t.Fatal(err)
+		}
+		assert.NotZero(t, joined.Bytes()[3]&2)
+		hdr := readJoined(t, joined.Bytes(), want)
+		assert.Equal(t, first.Name, hdr.Name)
+		assert.Equal(t, first.Comment, hdr.Comment)
+		assert.Equal(t, first.ModTime, hdr.ModTime)
+		assert.Equal(t, first.OS, hdr.OS)
+		assert.Equal(t, appendSubfield(nil, 'A', 'b', []byte("kept")), hdr.Extra)
+
+		joined.Reset()
+		if err := ConcatGzipWith(joined, readers(seekable), WithFirstHeader(), WithNewestModTime()); err != nil {
+			t.Fatal(err)
+		}
+		hdr = readJoined(t, joined.Bytes(), want)
+		assert.Equal(t, first.Name, hdr.Name)
+		assert.Equal(t, newer, hdr.ModTime)
+	}
+
+	explicit := gzip.Header{Name: "all.log", Comment: "joined", ModTime: newer, OS: 3, Extra: []byte("xy\x00\x00")}
+	joined := new(bytes.Buffer)
+	if err := ConcatGzipWith(joined, readers(true), WithFirstHeader(), WithHeader(explicit), WithHeaderCRC()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, explicit, readJoined(t, joined.Bytes(), want))
+
+	err := ConcatGzipWith(io.Discard, readers(true), WithHeader(gzip.Header{Name: "€"}))
+	assert.Error(t, err)
+}
+
+func TestJoinerNewestModTime(t *testing.T) {
+	older := gzWithHeader(t, gzip.Header{ModTime: time.Unix(1600000000, 0)}, []byte("a"))
+	newer := gzWithHeader(t, gzip.Header{ModTime: time.Unix(1700000000, 0)}, []byte("b"))
+
+	// the header isn't patched in a stream
+	joined := new(bytes.Buffer)
+	j, err := NewGzipJoiner(joined, WithNewestModTime(), WithHeaderCRC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Zero(t, joined.Len())
+	assert.NoError(t, j.Append(bytes.NewReader(older)))
+	assert.NoError(t, j.Append(bytes.NewReader(newer)))
+	assert.NoError(t, j.Close())
+	assert.Equal(t, time.Unix(1600000000, 0), readJoined(t, joined.Bytes(), []byte("ab")).ModTime)
+
+	f, err := os.CreateTemp(t.TempDir(), "joined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	j, err = NewGzipJoiner(f, WithNewestModTime(), WithHeaderCRC(), WithEmbeddedManifest(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, j.Append(bytes.NewReader(older)))
+	assert.NoError(t, j.Append(bytes.NewReader(newer)))
+	assert.NoError(t, j.Close())
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, time.Unix(1700000000, 0), readJoined(t, data, []byte("ab")).ModTime)
+	m, err := ReadManifest(bytes.NewReader(data))
+	if assert.NoError(t, err) {
+		assert.Len(t, m.Segments, 2)
+	}
+}
+
+func TestNewestModTimeMembers(t *testing.T) {
+	// the newer time is that of the second member of the first input
+	members := append(gzWithHeader(t, gzip.Header{ModTime: time.Unix(2000, 0)}, []byte("a")),
+		gzWithHeader(t, gzip.Header{ModTime: time.Unix(5000, 0)}, []byte("b"))...)
+	other := gzWithHeader(t, gzip.Header{ModTime: time.Unix(1000, 0)}, []byte("c"))
+
+	for _, opts := range [][]Option{{WithNewestModTime()}, {WithNewestModTime(), WithParallel(2, 2)}} {
+		f, err := os.CreateTemp(t.TempDir(), "joined")
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs := []io.Reader{bytes.NewReader(members), bytes.NewReader(other)}
+		assert.NoError(t, ConcatGzipWith(f, inputs, opts...))
+		_ = f.Close()
+
+		data, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, time.Unix(5000, 0), readJoined(t, data, []byte("abc")).ModTime, "%d options", len(opts))
+	}
+}
@@ -0,0 +1,372 @@
+package dfjoin
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errJoinerClosed = errors.New("dfjoin: joiner is closed")
+
+// merger is implemented by gzMerger, zlibMerger and rawMerger.
+type merger interface {
+	// concat splices a compressed input into the output.
+	concat(ctx context.Context, r io.Reader) error
+	// finish ends the output stream and writes the trailer.
+	finish() error
+	// combine folds the checksum of the spliced input into the output checksum.
+	combine(in *InputStats)
+	// checksum records the checksum of the input compressed by the Joiner in in.
+	checksum(in *InputStats, crc32Sum, adler32Sum uint32)
+	format() Format
+	// worker returns a merger of the same format which doesn't write any header,
+	// to splice inputs ahead of time in a parallel join.
+	worker() (merger, error)
+	// Close frees the C buffers.
+	Close() error
+	base() *deflateMerger
+}
+
+// headerWriter is implemented by the mergers whose header may depend on the
+// first input, gzMerger and zlibMerger.
+type headerWriter interface {
+	// header writes the header unless it's been written, with the header of r,
+	// the first input if it isn't nil, and returns r rewound.
+	header(r io.Reader) (io.Reader, error)
+}
+
+// Option configures a Joiner.
+type Option func(*joinConfig)
+
+type joinConfig struct {
+	strict           bool
+	stats            *JoinStats
+	progress         func(Progress)
+	progressInterval int64
+	workers          int
+	inFlight         int
+	sums             *DeflateSums
+	manifest         *Manifest
+	embedManifest    bool
+	bitShift         bool
+	header           *gzip.Header
+	firstHeader      bool
+	newestModTime    bool
+	modTime          uint32 // the newest modification time read ahead by ConcatGzipWith
+	headerCRC        bool
+	lenientHeaderCRC bool
+	zlibDict         func(dictID uint32) ([]byte, error)
+	manifestReserve  int
+	prefix           []byte
+	separator        []byte
+	suffix           []byte
+}
+
+// WithStrict makes the Joiner check the checksum and the size recorded in the
+// trailer of every input against its decompressed data, a mismatch fails with
+// ErrChecksum, ErrCheckSize or ErrZlibSum.
+func WithStrict() Option {
+	return func(c *joinConfig) {
+		c.strict = true
+	}
+}
+
+// WithSeparator makes the Joiner insert sep between the inputs, e.g. a newline
+// between log files which may lack a trailing one. sep is compressed by zlib's
+// deflate at the boundary between inputs, its checksum is folded into the
+// trailer. It's inserted before every input appended by a GzipAppender.
+func WithSeparator(sep []byte) Option {
+	return func(c *joinConfig) {
+		c.separator = sep
+	}
+}
+
+// WithPrefix makes the Joiner insert p before the first input, it's written even
+// if no input is appended. It's ignored by GzipAppender.
+func WithPrefix(p []byte) Option {
+	return func(c *joinConfig) {
+		c.prefix = p
+	}
+}
+
+// WithSuffix makes the Joiner insert s after the last input when it's closed.
+// It's ignored by GzipAppender.
+func WithSuffix(s []byte) Option {
+	return func(c *joinConfig) {
+		c.suffix = s
+	}
+}
+
+// WithBitShift makes the Joiner shift the bits of every input so that it starts
+// right where the previous one ends, rather than aligning the output on a byte
+// boundary with empty blocks. The output is smaller, but the inputs are no longer
+// copied byte by byte. Inputs spliced by WithParallel workers, plain inputs and
+// segments copied by AppendSegment are still aligned.
+func WithBitShift() Option {
+	return func(c *joinConfig) {
+		c.bitShift = true
+	}
+}
+
+func newJoinConfig(opts []Option) *joinConfig {
+	cfg := &joinConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// Joiner concatenates compressed inputs appended one at a time into a single
+// compressed stream, the end-of-stream marker and the trailer are written by
+// Close, so there is no need to know which input is the last ahead of time.
+type Joiner struct {
+	m       merger
+	cfg     *joinConfig
+	n       int  // number of appended inputs
+	started bool // whether the prefix has been written
+	stats   JoinStats
+
+	start        int64 // output offset where the input being appended starts
+	startBit     uint
+	uncompressed int64 // uncompressed size of the output so far
+	segments     []Segment
+
+	err    error
+	closed bool
+}
+
+// NewGzipJoiner writes a gzip header to w and returns a Joiner for gzip inputs.
+// With WithFirstHeader or WithNewestModTime the header is written along with the
+// first input instead.
+func NewGzipJoiner(w io.Writer, opts ...Option) (*Joiner, error) {
+	cfg := newJoinConfig(opts)
+	gm, err := newGzMerger(w, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Joiner{m: gm, cfg: cfg}, nil
+}
+
+// NewZlibJoiner writes a zlib header to w and returns a Joiner for zlib inputs.
+// With WithZlibDictionary the header is written along with the first input
+// instead.
+func NewZlibJoiner(w io.Writer, opts ...Option) (*Joiner, error) {
+	cfg := newJoinConfig(opts)
+	zm, err := newZlibMerger(w, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Joiner{m: zm, cfg: cfg}, nil
+}
+
+// Append splices the compressed stream read from r into the output, or compresses
+// it if r is a *PlainInput. After an error the Joiner is unusable and the same
+// error is returned by subsequent calls.
+func (j *Joiner) Append(r io.Reader) error {
+	return j.AppendContext(context.Background(), r)
+}
+
+// AppendContext is like Append but gives up as soon as ctx is done, the Joiner
+// is unusable then.
+func (j *Joiner) AppendContext(ctx context.Context, r io.Reader) error {
+	if j.closed {
+		return errJoinerClosed
+	}
+	if j.err != nil {
+		return j.err
+	}
+	r, err := j.header(r)
+	if err != nil {
+		return err
+	}
+	if err = j.boundary(); err != nil {
+		return err
+	}
+
+	d := j.m.base()
+	d.in = &InputStats{}
+	d.onFeed = nil
+	var next int64
+	if j.cfg.progress != nil && j.cfg.progressInterval > 0 {
+		d.onFeed = func() {
+			if pos := d.pos(); pos >= next {
+				next = pos + j.cfg.progressInterval
+				j.cfg.progress(Progress{Input: j.n, CompressedBytes: pos, UncompressedBytes: d.in.UncompressedBytes})
+			}
+		}
+	}
+
+	_, plain := r.(*PlainInput)
+	if err := j.mark(d.bitShift && !plain); err != nil {
+		return j.done(err)
+	}
+	err = spliceInput(ctx, j.m, r)
+	d.in.CompressedBytes = d.pos()
+	return j.done(err)
+}
+
+// header writes the header of the output if it has been deferred until the
+// first input r, which may be nil, and returns r rewound.
+func (j *Joiner) header(r io.Reader) (io.Reader, error) {
+	hw, ok := j.m.(headerWriter)
+	if !ok {
+		return r, nil
+	}
+	r, err := hw.header(r)
+	if err != nil {
+		j.err = err
+	}
+	return r, err
+}
+
+// boundary writes the prefix before the first input, or the separator before
+// the next one.
+func (j *Joiner) boundary() error {
+	if _, err := j.header(nil); err != nil {
+		return err
+	}
+	p := j.cfg.separator
+	if !j.started {
+		p = j.cfg.prefix
+		j.started = true
+	}
+	if err := j.inject(p); err != nil {
+		j.err = fmt.Errorf("unable to insert separator: %w", err)
+		return j.err
+	}
+	return nil
+}
+
+// inject compresses p into the output between inputs, its checksum is folded
+// into the output checksum but it isn't counted as an input.
+func (j *Joiner) inject(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	d := j.m.base()
+	in, onFeed := d.in, d.onFeed
+	d.in, d.onFeed = &InputStats{}, nil
+	defer func() {
+		d.in, d.onFeed = in, onFeed
+	}()
+
+	crc32Sum, adler32Sum, err := d.compress(context.Background(), Plain(p))
+	if err != nil {
+		return err
+	}
+	j.m.checksum(d.in, crc32Sum, adler32Sum)
+	j.m.combine(d.in)
+	j.uncompressed += d.in.UncompressedBytes
+	return nil
+}
+
+// mark aligns the output unless the next input is to be shifted, and records
+// where it starts.
+func (j *Joiner) mark(shift bool) error {
+	d := j.m.base()
+	if !shift {
+		if err := d.align(); err != nil {
+			return err
+		}
+	}
+	j.start, j.startBit = d.written(), d.lastBits
+	return nil
+}
+
+// done records the statistics of the input just spliced, or the error which
+// failed it.
+func (j *Joiner) done(err error) error {
+	d := j.m.base()
+	j.stats.Inputs = append(j.stats.Inputs, *d.in)
+	if err != nil {
+		ce := newConcatError(0, err)
+		ce.Index = j.n
+		j.err = ce
+		return j.err
+	}
+	if j.cfg.manifest != nil || j.cfg.embedManifest {
+		j.segments = append(j.segments, Segment{
+			Offset:            j.start,
+			Bit:               j.startBit,
+			Bits:              (d.written()-j.start)*8 + int64(d.lastBits) - int64(j.startBit),
+			UncompressedStart: j.uncompressed,
+			UncompressedBytes: d.in.UncompressedBytes,
+			Checksum:          d.in.Checksum,
+		})
+	}
+	j.uncompressed += d.in.UncompressedBytes
+	if j.cfg.progress != nil {
+		j.cfg.progress(Progress{Input: j.n, CompressedBytes: d.in.CompressedBytes,
+			UncompressedBytes: d.in.UncompressedBytes, Done: true})
+	}
+	j.n++
+	return nil
+}
+
+// appendAll appends the inputs in order, in parallel if WithParallel is set.
+func (j *Joiner) appendAll(ctx context.Context, inputs []io.Reader) error {
+	if j.cfg.workers > 0 {
+		return j.appendParallel(ctx, inputs)
+	}
+	for _, r := range inputs {
+		if err := j.AppendContext(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the statistics of the inputs appended so far.
+func (j *Joiner) Stats() JoinStats {
+	stats := j.stats
+	stats.Inputs = append([]InputStats(nil), j.stats.Inputs...)
+	if d := j.m.base(); d.cw != nil {
+		stats.BytesWritten = d.written()
+		stats.PaddingBytes = d.padding
+	}
+	return stats
+}
+
+// Close writes the end-of-stream marker and the trailer, and frees the
+// resources held by the Joiner. It doesn't close the underlying io.Writer.
+func (j *Joiner) Close() error {
+	if j.closed {
+		return j.err
+	}
+	j.closed = true
+	defer j.m.Close()
+
+	if j.err == nil && !j.started {
+		_ = j.boundary()
+	}
+	if j.err == nil {
+		if err := j.inject(j.cfg.suffix); err != nil {
+			j.err = fmt.Errorf("unable to insert suffix: %w", err)
+		}
+	}
+	if j.err == nil {
+		if err := j.m.finish(); err != nil {
+			j.err = fmt.Errorf("unable to finish: %w", err)
+		}
+	}
+	if gm, ok := j.m.(*gzMerger); ok && j.err == nil {
+		if err := gm.patchModTime(); err != nil {
+			j.err = fmt.Errorf("unable to patch the modification time: %w", err)
+		}
+	}
+	if gm, ok := j.m.(*gzMerger); ok && j.err == nil && j.cfg.embedManifest {
+		if err := gm.embedManifest(&Manifest{Format: FormatGzip, Segments: j.segments}); err != nil {
+			j.err = fmt.Errorf("unable to embed manifest: %w", err)
+		}
+	}
+	j.stats = j.Stats()
+	if j.cfg.stats != nil {
+		*j.cfg.stats = j.stats
+	}
+	if j.cfg.manifest != nil {
+		*j.cfg.manifest = Manifest{Format: j.m.format(), Segments: j.segments}
+	}
+	return j.err
+}
@@ -0,0 +1,185 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func genTestInputs(n int) [][]byte {
+	inputs := make([][]byte, n)
+	for i := range inputs {
+		inputs[i] = bytes.Repeat(text4Test[:rand.Intn(len(text4Test))], rand.Intn(100))
+	}
+	return inputs
+}
+
+func TestGzipJoiner(t *testing.T) {
+	inputs := genTestInputs(50)
+
+	joined := new(bytes.Buffer)
+	j, err := NewGzipJoiner(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, input := range inputs {
+		out := new(bytes.Buffer)
+		gw, _ := gzip.NewWriterLevel(out, i%10)
+		_, _ = gw.Write(input)
+		_ = gw.Close()
+		if err = j.Append(out); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+	}
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(t, j.Append(bytes.NewReader(nil)), errJoinerClosed)
+
+	gr, err := gzip.NewReader(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Join(inputs, nil), plain)
+}
+
+func TestZlibJoiner(t *testing.T) {
+	inputs := genTestInputs(50)
+
+	joined := new(bytes.Buffer)
+	j, err := NewZlibJoiner(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, input := range inputs {
+		out := new(bytes.Buffer)
+		zw, _ := zlib.NewWriterLevel(out, i%10)
+		_, _ = zw.Write(input)
+		_ = zw.Close()
+		if err = j.Append(out); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+	}
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zlib.NewReader(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Join(inputs, nil), plain)
+}
+
+func TestEmptyJoiner(t *testing.T) {
+	joined := new(bytes.Buffer)
+	j, err := NewGzipJoiner(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gr, err := gzip.NewReader(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Empty(t, plain)
+}
+
+func TestStrictJoin(t *testing.T) {
+	inputs := genTestInputs(3)
+
+	t.Run("gzip", func(t *testing.T) {
+		gz := make([][]byte, len(inputs))
+		for i, input := range inputs {
+			gz[i] = gzCompress(input)
+		}
+		gz[1][len(gz[1])-8]++
+
+		err := ConcatGzipWith(io.Discard, []io.Reader{bytes.NewReader(gz[0]), bytes.NewReader(gz[1]),
+			bytes.NewReader(gz[2])})
+		assert.NoError(t, err)
+
+		err = ConcatGzipWith(io.Discard, []io.Reader{bytes.NewReader(gz[0]), bytes.NewReader(gz[1]),
+			bytes.NewReader(gz[2])}, WithStrict())
+		assert.ErrorIs(t, err, ErrChecksum)
+		assert.Contains(t, err.Error(), "input 1")
+
+		gz[1][len(gz[1])-8]--
+		gz[2][len(gz[2])-1]++
+		err = ConcatGzipWith(io.Discard, []io.Reader{bytes.NewReader(gz[0]), bytes.NewReader(gz[1]),
+			bytes.NewReader(gz[2])}, WithStrict())
+		assert.ErrorIs(t, err, ErrCheckSize)
+		assert.Contains(t, err.Error(), "input 2")
+	})
+
+	t.Run("zlib", func(t *testing.T) {
+		zl := make([][]byte, len(inputs))
+		for i, input := range inputs {
+			out := new(bytes.Buffer)
+			zw := zlib.NewWriter(out)
+			_, _ = zw.Write(input)
+			_ = zw.Close()
+			zl[i] = out.Bytes()
+		}
+		zl[0][len(zl[0])-1]++
+
+		err := ConcatZlibWith(io.Discard, []io.Reader{bytes.NewReader(zl[0]), bytes.NewReader(zl[1]),
+			bytes.NewReader(zl[2])}, WithStrict())
+		assert.ErrorIs(t, err, ErrZlibSum)
+		assert.Contains(t, err.Error(), "input 0")
+	})
+}
+
+// cancelReader cancels the context once it's read.
+type cancelReader struct {
+	io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	c.cancel()
+	return c.Reader.Read(p)
+}
+
+func TestConcatContext(t *testing.T) {
+	gz1 := generateGzOut(1 << 20)
+	gz2 := generateGzOut(1 << 22)
+
+	t.Run("gzip", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		err := ConcatGzipContext(ctx, io.Discard, bytes.NewReader(gz1),
+			&cancelReader{Reader: bytes.NewReader(gz2), cancel: cancel})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Contains(t, err.Error(), "input 1")
+	})
+
+	t.Run("zlib", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := ConcatZlibContext(ctx, io.Discard, bytes.NewReader(generateZlibOut(1<<16)))
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Contains(t, err.Error(), "input 0")
+	})
+}
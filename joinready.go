@@ -0,0 +1,354 @@
+package dfjoin
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+/*
+#include "dfjoin.h"
+*/
+import "C"
+
+// the FEXTRA subfield ID of a join-ready gzip member, and the length of its data
+const (
+	joinReadySI1 = 'D'
+	joinReadySI2 = 'R'
+	joinReadyLen = 1 + 8 + 8 + 4
+)
+
+// joinReadyInfo is the data of the join-ready subfield: the bit offset of the
+// last block from the start of the deflate data, the uncompressed size and the
+// CRC-32 of the member.
+type joinReadyInfo struct {
+	lastBlock int64
+	size      int64
+	crc32Sum  uint32
+}
+
+func (info *joinReadyInfo) marshal() []byte {
+	data := make([]byte, joinReadyLen)
+	data[0] = 1
+	binary.LittleEndian.PutUint64(data[1:], uint64(info.lastBlock))
+	binary.LittleEndian.PutUint64(data[9:], uint64(info.size))
+	binary.LittleEndian.PutUint32(data[17:], info.crc32Sum)
+	return data
+}
+
+// parseJoinReady returns the join-ready subfield of the gzip extra field, if it
+// has been filled in.
+func parseJoinReady(extra []byte) (*joinReadyInfo, bool) {
+	data, ok := gzipSubfield(extra, joinReadySI1, joinReadySI2)
+	if !ok || len(data) != joinReadyLen || data[0] != 1 {
+		return nil, false
+	}
+	info := &joinReadyInfo{
+		lastBlock: int64(binary.LittleEndian.Uint64(data[1:])),
+		size:      int64(binary.LittleEndian.Uint64(data[9:])),
+		crc32Sum:  binary.LittleEndian.Uint32(data[17:]),
+	}
+	if info.lastBlock < 0 || info.size < 0 {
+		return nil, false
+	}
+	return info, true
+}
+
+// JoinReadyWriter is a gzip writer compressing with zlib's deflate, whose output
+// is joined without being inflated. Its header has an FEXTRA subfield with ID
+// "DR" recording where the deflate data end with an empty last block, the
+// uncompressed size and the CRC-32, which ConcatGzip and the Joiner use to copy
+// the deflate data of a seekable input up to that block as is. Gzip readers
+// ignore the subfield.
+type JoinReadyWriter struct {
+	w      io.WriteSeeker
+	start  int64
+	z      *deflater
+	cw     *countingWriter
+	bw     *bufio.Writer
+	info   joinReadyInfo
+	err    error
+	closed bool
+}
+
+// NewJoinReadyWriter writes the gzip header to w and returns a JoinReadyWriter
+// compressing at level, from NoCompression to BestCompression or
+// DefaultCompression. The subfield is patched in the header when it's closed.
+func NewJoinReadyWriter(w io.WriteSeeker, level int) (*JoinReadyWriter, error) {
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the output offset: %w", err)
+	}
+	z, err := newDeflater(level, -15, 8, DefaultStrategy)
+	if err != nil {
+		return nil, err
+	}
+	jw := &JoinReadyWriter{w: w, start: start, z: z}
+	jw.bw = bufio.NewWriter(w)
+	jw.cw = &countingWriter{w: jw.bw}
+
+	// the subfield is left empty until the writer is closed
+	if _, err = jw.cw.Write(subfieldHeader(joinReadySI1, joinReadySI2, make([]byte, joinReadyLen))); err != nil {
+		_ = z.Close()
+		return nil, fmt.Errorf("unable to output gzip header: %w", err)
+	}
+	return jw, nil
+}
+
+// Write compresses p.
+func (jw *JoinReadyWriter) Write(p []byte) (int, error) {
+	if jw.closed {
+		return 0, errWriterClosed
+	}
+	if jw.err != nil {
+		return 0, jw.err
+	}
+	if jw.err = jw.z.write(jw.cw, p, C.Z_NO_FLUSH); jw.err != nil {
+		return 0, jw.err
+	}
+	jw.info.crc32Sum = crc32.Update(jw.info.crc32Sum, crc32.IEEETable, p)
+	jw.info.size += int64(len(p))
+	return len(p), nil
+}
+
+// Flush compresses the pending data with a sync flush and flushes them to the
+// output.
+func (jw *JoinReadyWriter) Flush() error {
+	if jw.closed {
+		return errWriterClosed
+	}
+	if jw.err != nil {
+		return jw.err
+	}
+	if jw.err = jw.z.write(jw.cw, nil, C.Z_SYNC_FLUSH); jw.err != nil {
+		return jw.err
+	}
+	jw.err = jw.bw.Flush()
+	return jw.err
+}
+
+// Close ends the deflate data with an empty last block, writes the trailer and
+// fills in the subfield of the header. It doesn't close the underlying
+// io.WriteSeeker.
+func (jw *JoinReadyWriter) Close() error {
+	if jw.closed {
+		return jw.err
+	}
+	jw.closed = true
+	defer jw.z.Close()
+	if jw.err != nil {
+		return jw.err
+	}
+	jw.err = jw.finish()
+	return jw.err
+}
+
+func (jw *JoinReadyWriter) finish() error {
+	// complete the current block, so that the last block is an empty one
+	// following the bits left pending
+	if err := jw.z.write(jw.cw, nil, C.Z_BLOCK); err != nil {
+		return err
+	}
+	var (
+		pending C.uint
+		bits    C.int
+	)
+	if ret := C.deflatePending(jw.z.stream, &pending, &bits); ret != C.Z_OK || pending != 0 {
+		return fmt.Errorf("unable to get pending bits: %d", int(ret))
+	}
+	jw.info.lastBlock = (jw.cw.n-int64(len(simpleGzipHeader))-6-joinReadyLen)*8 + int64(bits)
+	if err := jw.z.write(jw.cw, nil, C.Z_FINISH); err != nil {
+		return err
+	}
+
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:], jw.info.crc32Sum)
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(jw.info.size))
+	if _, err := jw.cw.Write(trailer[:]); err != nil {
+		return fmt.Errorf("unable to output gzip trailer: %w", err)
+	}
+	if err := jw.bw.Flush(); err != nil {
+		return fmt.Errorf("unable to flush write buffer: %w", err)
+	}
+
+	if _, err := jw.w.Seek(jw.start+int64(len(simpleGzipHeader))+6, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := jw.w.Write(jw.info.marshal()); err != nil {
+		return fmt.Errorf("unable to patch the join-ready subfield: %w", err)
+	}
+	_, err := jw.w.Seek(jw.start+jw.cw.n, io.SeekStart)
+	return err
+}
+
+// randomAccess reads an input at offsets relative to its position when it was
+// opened, without moving it, with io.ReaderAt or else by seeking back and forth.
+type randomAccess struct {
+	s     io.Seeker
+	r     io.Reader
+	start int64
+}
+
+// newRandomAccess returns nil if r isn't an io.Seeker or can't seek.
+func newRandomAccess(r io.Reader) *randomAccess {
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return nil
+	}
+	start, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	return &randomAccess{s: s, r: r, start: start}
+}
+
+// size returns the number of bytes from the start to the end of the input.
+func (a *randomAccess) size() (int64, error) {
+	cur, err := a.s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := a.s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = a.s.Seek(cur, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("unable to seek back the input: %w", err)
+	}
+	return end - a.start, nil
+}
+
+// readAt reads len(p) bytes at off, or up to the end of the input with io.EOF.
+func (a *randomAccess) readAt(p []byte, off int64) (int, error) {
+	if ra, ok := a.r.(io.ReaderAt); ok {
+		return ra.ReadAt(p, a.start+off)
+	}
+	cur, err := a.s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = a.s.Seek(a.start+off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(a.r, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	if _, serr := a.s.Seek(cur, io.SeekStart); serr != nil {
+		return n, fmt.Errorf("unable to seek back the input: %w", serr)
+	}
+	return n, err
+}
+
+// emptyLastBlock returns the length in bytes of the empty last block, stored or
+// with fixed codes, starting at bit pos of b[0] and padded with zero bits, or 0
+// if b doesn't start with one.
+func emptyLastBlock(b []byte, pos uint) int {
+	var acc uint32
+	for i := 0; i < 3 && i < len(b); i++ {
+		acc |= uint32(b[i]) << (8 * i)
+	}
+	acc >>= pos
+
+	switch {
+	case acc&0x3ff == 3:
+		// last-block bit, fixed codes and the end-of-block code
+		n := int(pos+10+7) / 8
+		if len(b) < n || acc>>10&(1<<(uint(n*8)-pos-10)-1) != 0 {
+			return 0
+		}
+		return n
+	case acc&7 == 1:
+		// last-block bit, stored, and a zero length
+		n := int(pos+3+7) / 8
+		if len(b) < n+4 || acc>>3&(1<<(uint(n*8)-pos-3)-1) != 0 {
+			return 0
+		}
+		if b[n] != 0 || b[n+1] != 0 || b[n+2] != 0xff || b[n+3] != 0xff {
+			return 0
+		}
+		return n + 4
+	}
+	return 0
+}
+
+// joinReady returns the join-ready subfield of the member whose header has just
+// been read if the member can be spliced without inflating it: the end of the
+// member must match the subfield, which is checked ahead, and the output is
+// aligned first, which fails in bit-shift mode if bits are pending. An input
+// which can't be read at random is inflated as usual since a stale subfield
+// would only be found out once the member is copied.
+func (g *gzMerger) joinReady(a *randomAccess, extra []byte) (*joinReadyInfo, bool, error) {
+	info, ok := parseJoinReady(extra)
+	if !ok || g.cfg.strict || a == nil {
+		return nil, false, nil
+	}
+	b := make([]byte, 6+8)
+	n, err := a.readAt(b, g.pos()+info.lastBlock/8)
+	if err != nil && !errors.Is(err, io.EOF) || matchJoinReady(b[:n], info) == 0 {
+		return nil, false, nil
+	}
+	if !g.bitShift {
+		if err = g.align(); err != nil {
+			return nil, false, err
+		}
+	}
+	return info, g.lastBits == 0, nil
+}
+
+// matchJoinReady returns the length of the empty last block at the start of b
+// if it's followed by the trailer recorded by info, or 0.
+func matchJoinReady(b []byte, info *joinReadyInfo) int {
+	n := emptyLastBlock(b, uint(info.lastBlock%8))
+	if n == 0 || len(b) < n+8 {
+		return 0
+	}
+	if binary.LittleEndian.Uint32(b[n:]) != info.crc32Sum || binary.LittleEndian.Uint32(b[n+4:]) != uint32(info.size) {
+		return 0
+	}
+	return n
+}
+
+// spliceJoinReady copies the deflate data of a join-ready member up to its empty
+// last block, and skips that block and the trailer. ctx is checked before
+// copying every chunk of data.
+func (g *gzMerger) spliceJoinReady(ctx context.Context, br *bufio.Reader, info *joinReadyInfo) error {
+	for n := info.lastBlock / 8; n > 0; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		copied, err := io.CopyN(g.w, br, minInt64(n, BufSize))
+		n -= copied
+		if err != nil {
+			return fmt.Errorf("unable to copy deflate data: %w", err)
+		}
+		g.feed()
+	}
+
+	b, err := br.Peek(6 + 8)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("unable to read the last block: %w", err)
+	}
+	n := matchJoinReady(b, info)
+	if n == 0 {
+		return fmt.Errorf("%w: join-ready subfield doesn't match the end of the member", ErrHeader)
+	}
+	bits := uint(info.lastBlock % 8)
+	if err = g.pushBits(b[0]&(1<<bits-1), bits); err != nil {
+		return err
+	}
+	if _, err = br.Discard(n + 8); err != nil {
+		return fmt.Errorf("unable to read gzip trailer: %w", err)
+	}
+	// the blocks before the last one can't be told apart without inflating them
+	if info.lastBlock > 0 {
+		g.in.Blocks++
+	}
+	g.in.Blocks++
+	g.in.UncompressedBytes += info.size
+	return nil
+}
@@ -0,0 +1,154 @@
+package dfjoin
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func joinReadyFile(t *testing.T, path string, p []byte, level int, flush bool) []byte {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	jw, err := NewJoinReadyWriter(f, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := len(p) / 2
+	_, err = jw.Write(p[:half])
+	assert.NoError(t, err)
+	if flush {
+		assert.NoError(t, jw.Flush())
+	}
+	_, err = jw.Write(p[half:])
+	assert.NoError(t, err)
+	assert.NoError(t, jw.Close())
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestJoinReadyWriter(t *testing.T) {
+	dir := t.TempDir()
+	inputs := genTestInputs(22)
+	inputs[0] = []byte{}
+	files := make([][]byte, len(inputs))
+	for i, input := range inputs {
+		files[i] = joinReadyFile(t, filepath.Join(dir, "in.gz"), input, i%11-1, i%2 == 0)
+		assert.Equal(t, input, readSingleMember(t, bytes.NewReader(files[i])))
+	}
+	files = append(files, gzCompress([]byte("a plain gzip input")))
+	want := append(bytes.Join(inputs, nil), "a plain gzip input"...)
+
+	for _, seekable := range []bool{true, false} {
+		readers := make([]io.Reader, len(files))
+		for i, data := range files {
+			readers[i] = bytes.NewReader(data)
+			if !seekable {
+				readers[i] = struct{ io.Reader }{readers[i]}
+			}
+		}
+		var stats JoinStats
+		joined := new(bytes.Buffer)
+		if err := ConcatGzipWith(joined, readers, WithStats(&stats)); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, want, readSingleMember(t, bytes.NewReader(joined.Bytes())))
+		for i, in := range stats.Inputs {
+			assert.NotZero(t, in.Blocks, "input %d", i)
+			assert.Equal(t, int64(len(files[i])), in.CompressedBytes)
+		}
+	}
+}
+
+func TestJoinReadyMismatch(t *testing.T) {
+	input := []byte("the subfield of this input is stale")
+	data := joinReadyFile(t, filepath.Join(t.TempDir(), "in.gz"), input, DefaultCompression, false)
+	// the CRC recorded by the subfield
+	data[len(simpleGzipHeader)+6+17] ^= 0xff
+
+	var stats JoinStats
+	joined := new(bytes.Buffer)
+	assert.NoError(t, ConcatGzipWith(joined, []io.Reader{bytes.NewReader(data)}, WithStats(&stats)))
+	assert.Equal(t, input, readSingleMember(t, bytes.NewReader(joined.Bytes())))
+	assert.NotZero(t, stats.Inputs[0].Blocks)
+
+	// an input which can't be read at random is inflated rather than trusted
+	stats = JoinStats{}
+	joined.Reset()
+	assert.NoError(t, ConcatGzipWith(joined, []io.Reader{struct{ io.Reader }{bytes.NewReader(data)}}, WithStats(&stats)))
+	assert.Equal(t, input, readSingleMember(t, bytes.NewReader(joined.Bytes())))
+	assert.NotZero(t, stats.Inputs[0].Blocks)
+}
+
+func TestJoinReadyMembers(t *testing.T) {
+	dir := t.TempDir()
+	first := joinReadyFile(t, filepath.Join(dir, "a.gz"), []byte("first member\n"), DefaultCompression, false)
+	second := joinReadyFile(t, filepath.Join(dir, "b.gz"), []byte("second member\n"), DefaultCompression, true)
+	want := "first member\nsecond member\n"
+
+	// cat a.gz b.gz, the second member follows the bits left by the first one
+	var stats JoinStats
+	joined := new(bytes.Buffer)
+	members := append(append([]byte(nil), first...), second...)
+	assert.NoError(t, ConcatGzipWith(joined, []io.Reader{bytes.NewReader(members)}, WithStats(&stats)))
+	assert.Equal(t, []byte(want), readSingleMember(t, bytes.NewReader(joined.Bytes())))
+	assert.Equal(t, 4, stats.Inputs[0].Blocks)
+
+	// a corrupt body in the second member is copied as is since it isn't inflated
+	members[len(first)+len(simpleGzipHeader)+6+joinReadyLen] ^= 0xff
+	assert.NoError(t, ConcatGzip(io.Discard, bytes.NewReader(members)))
+	assert.Error(t, ConcatGzip(io.Discard, struct{ io.Reader }{bytes.NewReader(members)}))
+}
+
+func BenchmarkJoinReady(b *testing.B) {
+	p := bytes.Repeat([]byte("a line of a log file joined without inflating it\n"), 1<<16)
+	f, err := os.Create(filepath.Join(b.TempDir(), "in.gz"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	jw, err := NewJoinReadyWriter(f, DefaultCompression)
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, _ = jw.Write(p)
+	if err = jw.Close(); err != nil {
+		b.Fatal(err)
+	}
+	joinReady, err := os.ReadFile(f.Name())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	inputs := make([]io.Reader, 16)
+	for _, mode := range []struct {
+		name string
+		data []byte
+	}{
+		{"inflate", flushedGzip(p)},
+		{"join-ready", joinReady},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			b.SetBytes(int64(len(mode.data) * len(inputs)))
+			for i := 0; i < b.N; i++ {
+				for k := range inputs {
+					inputs[k] = bytes.NewReader(mode.data)
+				}
+				if err := ConcatGzipWith(io.Discard, inputs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package dfjoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var journalMagic = []byte("DFJ1")
+
+// size of the fields following the magic, tail excluded
+const journalFixed = 29
+
+// appendJournal records the bytes of a gzip file an append overwrites, so that
+// the file can be restored if the append doesn't complete. It's written to
+// the sidecar file journalPath(path) and synced before the gzip file is touched,
+// and removed once the appended file is synced.
+type appendJournal struct {
+	size      int64 // size of the file before the append
+	finalOff  int64 // offset of the byte holding the last-block bit of the final block
+	finalByte byte  // the byte at finalOff before it's patched
+	off       int64 // offset from which the file is rewritten
+	tail      []byte
+}
+
+func journalPath(path string) string {
+	return path + ".journal"
+}
+
+func (jr *appendJournal) marshal() []byte {
+	b := make([]byte, len(journalMagic)+journalFixed, len(journalMagic)+journalFixed+len(jr.tail)+4)
+	copy(b, journalMagic)
+	h := b[len(journalMagic):]
+	binary.LittleEndian.PutUint64(h, uint64(jr.size))
+	binary.LittleEndian.PutUint64(h[8:], uint64(jr.finalOff))
+	h[16] = jr.finalByte
+	binary.LittleEndian.PutUint64(h[17:], uint64(jr.off))
+	binary.LittleEndian.PutUint32(h[25:], uint32(len(jr.tail)))
+	b = append(b, jr.tail...)
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc32.ChecksumIEEE(b))
+	return append(b, sum[:]...)
+}
+
+// unmarshal fails if b isn't a complete journal, which is the case if we crashed
+// while writing it.
+func (jr *appendJournal) unmarshal(b []byte) error {
+	if len(b) < len(journalMagic)+journalFixed+4 || !bytes.Equal(b[:len(journalMagic)], journalMagic) {
+		return errors.New("truncated journal")
+	}
+	sum := binary.LittleEndian.Uint32(b[len(b)-4:])
+	b = b[:len(b)-4]
+	if crc32.ChecksumIEEE(b) != sum {
+		return errors.New("journal checksum mismatch")
+	}
+	b = b[len(journalMagic):]
+	jr.size = int64(binary.LittleEndian.Uint64(b))
+	jr.finalOff = int64(binary.LittleEndian.Uint64(b[8:]))
+	jr.finalByte = b[16]
+	jr.off = int64(binary.LittleEndian.Uint64(b[17:]))
+	n := binary.LittleEndian.Uint32(b[25:])
+	if int(n) != len(b)-journalFixed {
+		return errors.New("malformed journal")
+	}
+	jr.tail = append([]byte(nil), b[journalFixed:]...)
+	return nil
+}
+
+// writeJournal writes jr for the gzip file at path and makes it durable.
+func writeJournal(path string, jr *appendJournal) error {
+	f, err := os.OpenFile(journalPath(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to create journal: %w", err)
+	}
+	if _, err = f.Write(jr.marshal()); err == nil {
+		err = f.Sync()
+	}
+	if ex := f.Close(); ex != nil && err == nil {
+		err = ex
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write journal: %w", err)
+	}
+	return syncDir(path)
+}
+
+// removeJournal commits the append, the file can't be rolled back afterwards.
+func removeJournal(path string) error {
+	if err := os.Remove(journalPath(path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to remove journal: %w", err)
+	}
+	return syncDir(path)
+}
+
+// rollback restores the file f as recorded by jr.
+func (jr *appendJournal) rollback(f *os.File) error {
+	if _, err := f.WriteAt(jr.tail, jr.off); err != nil {
+		return fmt.Errorf("unable to restore the trailer: %w", err)
+	}
+	if _, err := f.WriteAt([]byte{jr.finalByte}, jr.finalOff); err != nil {
+		return fmt.Errorf("unable to restore the final block: %w", err)
+	}
+	if err := f.Truncate(jr.size); err != nil {
+		return fmt.Errorf("unable to truncate: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("unable to sync: %w", err)
+	}
+	return nil
+}
+
+// recoverGzipFile rolls back the append to f left unfinished by a crash, if any.
+func recoverGzipFile(path string, f *os.File) error {
+	b, err := os.ReadFile(journalPath(path))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("unable to read journal: %w", err)
+	}
+
+	// an incomplete journal means the file hasn't been touched yet
+	var jr appendJournal
+	if jr.unmarshal(b) == nil {
+		if err = jr.rollback(f); err != nil {
+			return fmt.Errorf("unable to roll back the unfinished append: %w", err)
+		}
+	}
+	return removeJournal(path)
+}
+
+// syncDir makes the creation or the removal of a file in the directory of path
+// durable. Directories can't be synced on every platform, errors are ignored.
+func syncDir(path string) error {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+	_ = d.Sync()
+	return d.Close()
+}
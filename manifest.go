@@ -0,0 +1,316 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+)
+
+/*
+#include "dfjoin.h"
+*/
+import "C"
+
+// ErrNoManifest is returned by ReadManifest if the gzip stream doesn't embed a manifest.
+var ErrNoManifest = errors.New("dfjoin: no manifest embedded")
+
+// DefaultManifestReserve is the size of the header slot reserved by
+// WithEmbeddedManifest by default, enough for about 150 segments.
+const DefaultManifestReserve = 4096
+
+// the FEXTRA subfield ID of an embedded manifest, and the kinds of its data
+const (
+	manifestSI1         = 'D'
+	manifestSI2         = 'J'
+	manifestPlaceholder = 0 // a reserved slot which hasn't been patched
+	manifestInline      = 1
+)
+
+// Format is the format of a compressed stream.
+type Format int
+
+const (
+	FormatGzip Format = iota
+	FormatZlib
+	FormatDeflate // raw deflate
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatGzip:
+		return "gzip"
+	case FormatZlib:
+		return "zlib"
+	case FormatDeflate:
+		return "deflate"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// Segment describes where an input lies in the joined output. Every input
+// starts with an empty history window, so it can be inflated on its own.
+type Segment struct {
+	Offset            int64  // offset in the output of the byte where the first block of the input starts
+	Bit               uint   // position in that byte of the first bit of the block, 0 to 7
+	Bits              int64  // length of the deflate data of the input in bits
+	UncompressedStart int64  // offset of the input in the uncompressed output
+	UncompressedBytes int64  // uncompressed size of the input
+	Checksum          uint32 // CRC-32 for gzip and raw deflate, Adler-32 for zlib
+}
+
+// Manifest records how the inputs have been joined, see WithManifest.
+type Manifest struct {
+	Format   Format
+	Segments []Segment
+}
+
+// WithManifest makes the Joiner fill m with the segment of every input in the
+// output when it's closed. The data inserted by WithPrefix, WithSeparator and
+// WithSuffix don't have a segment but account for the uncompressed offsets.
+func WithManifest(m *Manifest) Option {
+	return func(c *joinConfig) {
+		c.manifest = m
+	}
+}
+
+// WithEmbeddedManifest makes a gzip Joiner store its manifest in an FEXTRA
+// subfield with ID "DJ", which ReadManifest parses back. If the output is an
+// io.WriteSeeker, reserve bytes are reserved in the header for the subfield and
+// patched when the Joiner is closed. Otherwise, or if the manifest doesn't fit,
+// it's written to the header of an empty gzip member at the end of the output,
+// which gzip readers skip. reserve not above 0 means DefaultManifestReserve. It
+// has no effect on zlib and raw deflate joiners, and appending to the output
+// afterwards makes the manifest stale.
+func WithEmbeddedManifest(reserve int) Option {
+	return func(c *joinConfig) {
+		if reserve <= 0 {
+			reserve = DefaultManifestReserve
+		}
+		if reserve > 0xffff-4 {
+			reserve = 0xffff - 4
+		}
+		c.embedManifest = true
+		c.manifestReserve = reserve
+	}
+}
+
+// ReadManifest reads the manifest embedded by WithEmbeddedManifest in the gzip
+// stream r. If it's not in the header of the first member, the members are
+// inflated to find the one holding it at the end.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	d := &deflateMerger{}
+	if err := d.init(io.Discard); err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	br := d.open(r)
+	var hdr gzip.Header
+	for member := 0; ; member++ {
+		if _, err := readGzipHeader(br, &hdr, false); err != nil {
+			return nil, newConcatError(d.pos(), fmt.Errorf("unable to read the gzip header of member %d: %w", member, err))
+		}
+		if data, ok := gzipSubfield(hdr.Extra, manifestSI1, manifestSI2); ok && len(data) > 0 && data[0] == manifestInline {
+			return unmarshalManifest(data)
+		}
+
+		if _, err := d.splice(context.Background(), br, crc32.NewIEEE()); err != nil {
+			return nil, newConcatError(d.pos(), err)
+		}
+		d.lastBits = 0
+		if _, err := br.Discard(8); err != nil {
+			return nil, newConcatError(d.pos(), fmt.Errorf("unable to read gzip trailer of member %d: %w", member, err))
+		}
+		if _, err := br.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, ErrNoManifest
+			}
+			return nil, fmt.Errorf("unable to read next member: %w", err)
+		}
+	}
+}
+
+// subfieldHeader returns a gzip header whose FEXTRA holds the single subfield
+// si1, si2 with data, which starts at offset len(simpleGzipHeader)+6.
+func subfieldHeader(si1, si2 byte, data []byte) []byte {
+	header := make([]byte, len(simpleGzipHeader), len(simpleGzipHeader)+6+len(data))
+	copy(header, simpleGzipHeader)
+	header[3] |= 4
+	header = append(header, 0, 0)
+	binary.LittleEndian.PutUint16(header[10:], uint16(4+len(data)))
+	return appendSubfield(header, si1, si2, data)
+}
+
+// appendSubfield appends the subfield si1, si2 with data to the extra field.
+func appendSubfield(extra []byte, si1, si2 byte, data []byte) []byte {
+	extra = append(extra, si1, si2, byte(len(data)), byte(len(data)>>8))
+	return append(extra, data...)
+}
+
+// gzipSubfield returns the data of the subfield si1, si2 of the gzip extra field.
+func gzipSubfield(extra []byte, si1, si2 byte) ([]byte, bool) {
+	for len(extra) >= 4 {
+		n := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+n {
+			return nil, false
+		}
+		if extra[0] == si1 && extra[1] == si2 {
+			return extra[4 : 4+n], true
+		}
+		extra = extra[4+n:]
+	}
+	return nil, false
+}
+
+// embedManifest patches the manifest slot of the output header, or writes the
+// manifest at the end of the output in an empty member.
+func (g *gzMerger) embedManifest(m *Manifest) error {
+	data := m.marshal()
+	if g.slot > 0 && len(data) <= g.cfg.manifestReserve {
+		if err := g.patchHeader(g.slot, data); err != nil {
+			return fmt.Errorf("unable to patch the manifest slot: %w", err)
+		}
+		return nil
+	}
+
+	if len(data) > 0xffff-4 {
+		return fmt.Errorf("manifest of %d segments too large for the gzip extra field", len(m.Segments))
+	}
+	// an empty last block with fixed codes, and a zero trailer
+	member := append(subfieldHeader(manifestSI1, manifestSI2, data), 3, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	if _, err := g.w.Write(member); err != nil {
+		return fmt.Errorf("unable to output manifest member: %w", err)
+	}
+	if err := g.w.Flush(); err != nil {
+		return fmt.Errorf("unable to flush write buffer: %w", err)
+	}
+	return nil
+}
+
+func (m *Manifest) marshal() []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	data := []byte{manifestInline}
+	putUvarint := func(v uint64) {
+		data = append(data, scratch[:binary.PutUvarint(scratch[:], v)]...)
+	}
+	putUvarint(uint64(len(m.Segments)))
+	for _, seg := range m.Segments {
+		putUvarint(uint64(seg.Offset))
+		putUvarint(uint64(seg.Bit))
+		putUvarint(uint64(seg.Bits))
+		putUvarint(uint64(seg.UncompressedStart))
+		putUvarint(uint64(seg.UncompressedBytes))
+		binary.LittleEndian.PutUint32(scratch[:], seg.Checksum)
+		data = append(data, scratch[:4]...)
+	}
+	return data
+}
+
+func unmarshalManifest(data []byte) (*Manifest, error) {
+	br := bytes.NewReader(data[1:])
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("malformed manifest: %w", err)
+	}
+	m := &Manifest{Format: FormatGzip}
+	for i := uint64(0); i < count; i++ {
+		var fields [5]uint64
+		for k := range fields {
+			if fields[k], err = binary.ReadUvarint(br); err != nil {
+				return nil, fmt.Errorf("malformed manifest segment %d: %w", i, err)
+			}
+		}
+		var sum [4]byte
+		if _, err = io.ReadFull(br, sum[:]); err != nil {
+			return nil, fmt.Errorf("malformed manifest segment %d: %w", i, err)
+		}
+		m.Segments = append(m.Segments, Segment{
+			Offset:            int64(fields[0]),
+			Bit:               uint(fields[1]),
+			Bits:              int64(fields[2]),
+			UncompressedStart: int64(fields[3]),
+			UncompressedBytes: int64(fields[4]),
+			Checksum:          binary.LittleEndian.Uint32(sum[:]),
+		})
+	}
+	return m, nil
+}
+
+// ExtractSegment returns a reader of the uncompressed data of the input i of the
+// joined output r described by m, only the deflate data of the input are read
+// and inflated. The checksum recorded by m is verified at the end of data.
+func ExtractSegment(r io.ReaderAt, m *Manifest, i int) (io.ReadCloser, error) {
+	if i < 0 || i >= len(m.Segments) {
+		return nil, fmt.Errorf("segment %d out of range [0, %d)", i, len(m.Segments))
+	}
+	seg := m.Segments[i]
+
+	sr := &segmentReader{remaining: seg.UncompressedBytes, expected: seg.Checksum}
+	if m.Format == FormatZlib {
+		sr.sum = adler32.New()
+	} else {
+		sr.sum = crc32.NewIEEE()
+	}
+
+	off := seg.Offset
+	var prime []byte
+	if seg.Bit > 0 {
+		// the block starts in the middle of a byte, its bits are pushed to
+		// inflate's bit buffer ahead of the following bytes
+		prime = make([]byte, 1)
+		if _, err := r.ReadAt(prime, off); err != nil {
+			return nil, fmt.Errorf("unable to read segment %d: %w", i, err)
+		}
+		off++
+	}
+	size := (int64(seg.Bit)+seg.Bits+7)/8 - (off - seg.Offset)
+	if err := sr.init(io.NewSectionReader(r, off, size)); err != nil {
+		return nil, err
+	}
+	sr.ignoreLast = true
+	if prime != nil {
+		if ret := C.inflatePrime(sr.stream, C.int(8-seg.Bit), C.int(prime[0]>>seg.Bit)); ret != C.Z_OK {
+			_ = sr.Close()
+			return nil, fmt.Errorf("unable to prime inflate: %d", int(ret))
+		}
+	}
+	return sr, nil
+}
+
+// segmentReader inflates the data of a segment, which ends when its uncompressed
+// size is reached rather than at a last block.
+type segmentReader struct {
+	inflater
+	remaining int64
+	sum       hash.Hash32
+	expected  uint32
+}
+
+func (s *segmentReader) Read(p []byte) (n int, err error) {
+	if s.remaining == 0 {
+		if s.sum.Sum32() != s.expected {
+			return 0, fmt.Errorf("%w: expect 0x%x, got 0x%x", ErrChecksum, s.expected, s.sum.Sum32())
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err = s.read(p)
+	_, _ = s.sum.Write(p[:n])
+	s.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return n, s.concatError(0, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,175 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifest(t *testing.T) {
+	inputs := genTestInputs(30)
+
+	for _, parallel := range []bool{false, true} {
+		var readers []io.Reader
+		for i, input := range inputs {
+			if i%3 == 2 {
+				readers = append(readers, Plain(input))
+				continue
+			}
+			out := new(bytes.Buffer)
+			zw := zlib.NewWriter(out)
+			_, _ = zw.Write(input)
+			_ = zw.Close()
+			readers = append(readers, out)
+		}
+
+		var m Manifest
+		opts := []Option{WithManifest(&m), WithSeparator([]byte("\n"))}
+		if parallel {
+			opts = append(opts, WithParallel(4, 0))
+		}
+		joined := new(bytes.Buffer)
+		if err := ConcatZlibWith(joined, readers, opts...); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, FormatZlib, m.Format)
+		if !assert.Len(t, m.Segments, len(inputs)) {
+			continue
+		}
+
+		zr, err := zlib.NewReader(bytes.NewReader(joined.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ra := bytes.NewReader(joined.Bytes())
+		for i, seg := range m.Segments {
+			assert.Equal(t, inputs[i], plain[seg.UncompressedStart:seg.UncompressedStart+seg.UncompressedBytes])
+
+			sr, err := ExtractSegment(ra, &m, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(sr)
+			_ = sr.Close()
+			if err != nil {
+				t.Fatalf("segment %d: %v", i, err)
+			}
+			assert.Equal(t, inputs[i], data)
+		}
+	}
+}
+
+func TestExtractUnalignedSegment(t *testing.T) {
+	// stored blocks are aligned to the bytes of the output, the data must be
+	// compressible for the stream to be shifted
+	plain := bytes.Repeat([]byte("a segment starting in the middle of a byte\n"), 1000)
+	compressed := flateCompress(plain, 6)
+
+	for bit := uint(1); bit < 8; bit++ {
+		// a raw deflate stream starting at the bit of the second byte
+		shifted := make([]byte, len(compressed)+2)
+		shifted[0] = 0xa5
+		shifted[1] = 0xff >> (8 - bit)
+		for i, b := range compressed {
+			shifted[i+1] |= b << bit
+			shifted[i+2] = b >> (8 - bit)
+		}
+
+		m := &Manifest{Format: FormatDeflate, Segments: []Segment{{
+			Offset:            1,
+			Bit:               bit,
+			Bits:              int64(len(compressed)) * 8,
+			UncompressedBytes: int64(len(plain)),
+			Checksum:          crc32.ChecksumIEEE(plain),
+		}}}
+		sr, err := ExtractSegment(bytes.NewReader(shifted), m, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(sr)
+		_ = sr.Close()
+		if err != nil {
+			t.Fatalf("bit %d: %v", bit, err)
+		}
+		assert.Equal(t, plain, data)
+	}
+}
+
+func TestEmbeddedManifest(t *testing.T) {
+	inputs := genTestInputs(10)
+	readers := func() []io.Reader {
+		var readers []io.Reader
+		for _, input := range inputs {
+			readers = append(readers, bytes.NewReader(gzCompress(input)))
+		}
+		return readers
+	}
+
+	check := func(t *testing.T, joined []byte, inHeader bool) {
+		gr, err := gzip.NewReader(bytes.NewReader(joined))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := io.ReadAll(gr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, bytes.Join(inputs, nil), plain)
+		_, hasExtra := gzipSubfield(gr.Header.Extra, manifestSI1, manifestSI2)
+		assert.Equal(t, inHeader, hasExtra)
+
+		m, err := ReadManifest(bytes.NewReader(joined))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !assert.Len(t, m.Segments, len(inputs)) {
+			return
+		}
+		for i := range inputs {
+			sr, err := ExtractSegment(bytes.NewReader(joined), m, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(sr)
+			_ = sr.Close()
+			assert.NoError(t, err)
+			assert.Equal(t, inputs[i], data)
+		}
+	}
+
+	t.Run("trailing member", func(t *testing.T) {
+		joined := new(bytes.Buffer)
+		if err := ConcatGzipWith(joined, readers(), WithEmbeddedManifest(0)); err != nil {
+			t.Fatal(err)
+		}
+		check(t, joined.Bytes(), false)
+	})
+
+	for _, reserve := range []int{0, 16} {
+		f, err := os.CreateTemp(t.TempDir(), "joined")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ConcatGzipWith(f, readers(), WithEmbeddedManifest(reserve)); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+		joined, _ := os.ReadFile(f.Name())
+		// a slot too small is left as a placeholder
+		check(t, joined, true)
+	}
+
+	_, err := ReadManifest(bytes.NewReader(gzCompress(text4Test)))
+	assert.ErrorIs(t, err, ErrNoManifest)
+}
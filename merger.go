@@ -0,0 +1,482 @@
+package dfjoin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+
+	"github.com/zhyee/deflatejoin/internal"
+)
+
+/*
+#include "dfjoin.h"
+*/
+import "C"
+
+// deflateMerger splices raw deflate streams one after another, it owns the C
+// buffers shared by all the spliced inputs. The last byte of a spliced stream
+// is held in lastByte until we know what comes next: another stream, which
+// needs the output to be byte aligned, or the end of the output.
+type deflateMerger struct {
+	w          *bufio.Writer
+	cw         *countingWriter
+	zlibInBuf  unsafe.Pointer
+	zlibOutBuf unsafe.Pointer
+	lastByte   byte
+	lastBits   uint // number of valid bits in lastByte, 0 means nothing is pending
+
+	// offset in the input of the byte holding the last-block bit of the
+	// final block spliced, and the mask of that bit
+	finalOff  int64
+	finalMask byte
+
+	// the input being spliced
+	cr *countingReader
+	br *bufio.Reader
+	in *InputStats
+
+	padding int64  // total bytes of empty blocks written
+	onFeed  func() // called whenever compressed data is fed to inflate
+
+	// bitShift makes splice shift the stream being spliced to start right after
+	// the pending bits instead of aligning the output, skip is the number of
+	// bytes of the next chunk already written out
+	bitShift bool
+	skip     int
+
+	dict []byte // preset dictionary of the next stream spliced
+
+	// keepLast makes splice copy the stream as is, its last-block bit and the
+	// padding of its last byte included
+	keepLast bool
+}
+
+func (d *deflateMerger) base() *deflateMerger {
+	return d
+}
+
+// open starts splicing a new input, the returned reader is positioned at its
+// beginning.
+func (d *deflateMerger) open(r io.Reader) *bufio.Reader {
+	d.cr = &countingReader{r: r}
+	// one byte more than a chunk, for shift to peek at a block header running
+	// over the next chunk
+	d.br = bufio.NewReaderSize(d.cr, BufSize+1)
+	if d.in == nil {
+		d.in = &InputStats{}
+	}
+	return d.br
+}
+
+// pos returns the number of bytes consumed from the input being spliced.
+func (d *deflateMerger) pos() int64 {
+	return d.cr.n - int64(d.br.Buffered())
+}
+
+func (d *deflateMerger) init(w io.Writer) error {
+	d.zlibInBuf = C.malloc(BufSize)
+	d.zlibOutBuf = C.malloc(BufSize)
+
+	if d.zlibInBuf == nil || d.zlibOutBuf == nil {
+		_ = d.Close()
+		errMessage := C.errMessage()
+		return fmt.Errorf("unable to malloc memory for buffer: %s",
+			internal.UnsafeString((*byte)(unsafe.Pointer(errMessage)), int(C.strlen(errMessage))))
+	}
+	d.cw = &countingWriter{w: w}
+	d.w = bufio.NewWriter(d.cw)
+	return nil
+}
+
+// reset makes d write to w from now on.
+func (d *deflateMerger) reset(w io.Writer) {
+	d.cw = &countingWriter{w: w}
+	d.w.Reset(d.cw)
+}
+
+func (d *deflateMerger) Close() error {
+	if d.zlibInBuf != nil {
+		C.free(d.zlibInBuf)
+		d.zlibInBuf = nil
+	}
+	if d.zlibOutBuf != nil {
+		C.free(d.zlibOutBuf)
+		d.zlibOutBuf = nil
+	}
+	return nil
+}
+
+// splice copies the raw deflate stream from br to the output with the last-block
+// bit of its final block cleared, so that another stream can follow it. The
+// uncompressed data is written to sum, and the uncompressed size is returned.
+// br is left positioned right after the end of the deflate stream. ctx is checked
+// before inflating every chunk of data, splice returns ctx.Err() once it's done.
+// In bit-shift mode the stream starts right after the pending bits, see shift.
+func (d *deflateMerger) splice(ctx context.Context, br *bufio.Reader, sum io.Writer) (int64, error) {
+	var stream C.z_stream
+
+	if ret := C.initStream(&stream); ret != C.Z_OK {
+		return 0, fmt.Errorf("unable to init z_stream: %d", int(ret))
+	}
+	defer C.inflateEnd(&stream)
+	if err := setDictionary(&stream, d.dict); err != nil {
+		return 0, err
+	}
+	d.dict = nil
+
+	if !d.bitShift {
+		if err := d.align(); err != nil {
+			return 0, err
+		}
+	}
+
+	inputBuf := (*C.uchar)(d.zlibInBuf)
+	outputBuf := (*C.uchar)(d.zlibOutBuf)
+	in := unsafe.Slice((*byte)(d.zlibInBuf), BufSize)
+	out := unsafe.Slice((*byte)(d.zlibOutBuf), BufSize)
+
+	uncompressedSize64 := int64(0)
+
+	readSize, err := peekToBuf(&stream, br, inputBuf, 0)
+	if err != nil {
+		return 0, err
+	}
+	d.feed()
+
+	// in[:emitted] has been written out
+	emitted := 0
+	refill := func() error {
+		if err := d.emit(in[emitted:readSize]); err != nil {
+			return err
+		}
+		if readSize, err = peekToBuf(&stream, br, inputBuf, readSize); err != nil {
+			return err
+		}
+		d.feed()
+		emitted, d.skip = d.skip, 0
+		return nil
+	}
+
+	lastBlock := d.clearLast(in, 0, 1)
+	if emitted, err = d.shift(br, in[:readSize], emitted, 0, 0); err != nil {
+		return 0, err
+	}
+
+	done := ctx.Done()
+	for {
+		if done != nil {
+			select {
+			case <-done:
+				return 0, ctx.Err()
+			default:
+			}
+		}
+
+		if stream.avail_in == 0 {
+			if err = refill(); err != nil {
+				return 0, err
+			}
+		}
+
+		stream.next_out = outputBuf
+		stream.avail_out = BufSize
+
+		ret := C.inflate(&stream, C.Z_BLOCK)
+
+		if _, ok := inflateErrors[int(ret)]; ok {
+			ie := newInflateError(ret, &stream)
+			ie.offset = int64(readSize - int(stream.avail_in))
+			return 0, ie
+		}
+
+		produced := int(BufSize - stream.avail_out)
+		_, _ = sum.Write(out[:produced])
+		uncompressedSize64 += int64(produced)
+		d.in.UncompressedBytes += int64(produced)
+
+		if stream.data_type&C.int(128) != 0 {
+			d.in.Blocks++
+			if lastBlock {
+				break
+			}
+			pos := uint(stream.data_type & 7) // 00000111
+			idx := readSize - int(stream.avail_in)
+			if pos != 0 {
+				// the header of next block starts in the byte inflate has consumed
+				idx--
+				lastBlock = d.clearLast(in, idx, byte(int(0x100)>>pos))
+			} else {
+				if stream.avail_in == 0 {
+					if err = refill(); err != nil {
+						return 0, err
+					}
+					idx = 0
+				}
+				lastBlock = d.clearLast(in, idx, 1)
+			}
+			if emitted, err = d.shift(br, in[:readSize], emitted, idx, (8-pos)%8); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	consumed := readSize - int(stream.avail_in)
+	if d.keepLast {
+		if err = d.emit(in[emitted:consumed]); err != nil {
+			return 0, err
+		}
+	} else {
+		if err = d.emit(in[emitted : consumed-1]); err != nil {
+			return 0, err
+		}
+		pos := uint(stream.data_type & 7)
+		if err = d.pushBits(in[consumed-1]&byte(0xff>>pos), 8-pos); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err = br.Discard(consumed); err != nil {
+		return 0, fmt.Errorf("unable to skip deflate data: %w", err)
+	}
+	return uncompressedSize64, nil
+}
+
+// shift is called in bit-shift mode for the header of every block, which starts
+// at the bit s of in[idx], when the stream being spliced is shifted by the bits
+// pending in the output. A stored block must start its data on a byte boundary
+// of the output, so its header is written out followed by padding, and the
+// rest of the stream is copied unshifted. It returns the new number of bytes of
+// in written out.
+func (d *deflateMerger) shift(br *bufio.Reader, in []byte, emitted, idx int, s uint) (int, error) {
+	if !d.bitShift || d.lastBits == 0 {
+		return emitted, nil
+	}
+
+	header := uint32(in[idx])
+	if s+3 > 8 {
+		// the header runs over the next byte, which might be in the next chunk
+		if idx+1 < len(in) {
+			header |= uint32(in[idx+1]) << 8
+		} else {
+			next, err := br.Peek(len(in) + 1)
+			if err != nil {
+				return 0, fmt.Errorf("unable to read block header: %w", err)
+			}
+			header |= uint32(next[len(in)]) << 8
+		}
+	}
+	if header>>(s+1)&3 != 0 {
+		// not a stored block
+		return emitted, nil
+	}
+
+	if err := d.emit(in[emitted:idx]); err != nil {
+		return 0, err
+	}
+	if err := d.pushBits(byte(header), minUint(s+3, 8)); err != nil {
+		return 0, err
+	}
+	emitted = idx + 1
+	if s+3 > 8 {
+		if err := d.pushBits(byte(header>>8), s+3-8); err != nil {
+			return 0, err
+		}
+		emitted++
+	}
+	if d.lastBits > 0 {
+		if err := d.w.WriteByte(d.lastByte); err != nil {
+			return 0, fmt.Errorf("unable to output stored block header: %w", err)
+		}
+		d.lastBits = 0
+	}
+	if emitted > len(in) {
+		// the header's second byte is the first one of the next chunk
+		d.skip = emitted - len(in)
+		emitted = len(in)
+	}
+	return emitted, nil
+}
+
+// emit writes p to the output after the pending bits.
+func (d *deflateMerger) emit(p []byte) error {
+	if d.lastBits == 0 {
+		if _, err := d.w.Write(p); err != nil {
+			return fmt.Errorf("unable to output: %w", err)
+		}
+		return nil
+	}
+	for _, b := range p {
+		if err := d.w.WriteByte(d.lastByte | b<<d.lastBits); err != nil {
+			return fmt.Errorf("unable to output: %w", err)
+		}
+		d.lastByte = b >> (8 - d.lastBits)
+	}
+	return nil
+}
+
+// pushBits appends the n low bits of b, whose other bits are zero, to the pending
+// bits and writes out the byte they fill up, if any.
+func (d *deflateMerger) pushBits(b byte, n uint) error {
+	acc := uint16(d.lastByte)&(1<<d.lastBits-1) | uint16(b)<<d.lastBits
+	d.lastBits += n
+	if d.lastBits >= 8 {
+		if err := d.w.WriteByte(byte(acc)); err != nil {
+			return fmt.Errorf("unable to output last byte: %w", err)
+		}
+		acc >>= 8
+		d.lastBits -= 8
+	}
+	d.lastByte = byte(acc)
+	return nil
+}
+
+func minUint(a, b uint) uint {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// clearLast clears the last-block bit of a block header held by in[idx], unless
+// keepLast is set, and reports whether it was set, in is the chunk of input
+// being inflated.
+func (d *deflateMerger) clearLast(in []byte, idx int, mask byte) bool {
+	if in[idx]&mask == 0 {
+		return false
+	}
+	if !d.keepLast {
+		in[idx] &^= mask
+	}
+	d.finalOff = d.pos() + int64(idx)
+	d.finalMask = mask
+	return true
+}
+
+// align writes out the pending bits of the previous stream followed by empty
+// blocks, so that the output ends on a byte boundary and the next stream can be
+// copied as is, see gzjoin.c.
+func (d *deflateMerger) align() error {
+	if d.lastBits == 0 {
+		return nil
+	}
+
+	pos := 8 - d.lastBits
+	lastByte := d.lastByte
+	d.lastBits = 0
+
+	var buf [8]byte
+	b := buf[:0]
+	if pos&1 != 0 {
+		// odd, an empty stored block
+		b = append(b, lastByte)
+		if pos == 1 {
+			b = append(b, 0)
+		}
+		b = append(b, 0, 0, 255, 255)
+	} else {
+		// even, empty fixed blocks which are 10 bits long each
+		switch pos {
+		case 6:
+			b = append(b, lastByte|8)
+			lastByte = 0
+			fallthrough
+		case 4:
+			b = append(b, lastByte|0x20)
+			lastByte = 0
+			fallthrough
+		case 2:
+			b = append(b, lastByte|0x80, 0)
+		}
+	}
+	if _, err := d.w.Write(b); err != nil {
+		return fmt.Errorf("unable to output empty block: %w", err)
+	}
+	// the first byte holds the bits of previous stream
+	d.in.PaddingBytes += int64(len(b) - 1)
+	d.padding += int64(len(b) - 1)
+	return nil
+}
+
+// finish terminates the output deflate stream by an empty last block with fixed
+// codes, which is written right after the pending bits of the previous stream.
+func (d *deflateMerger) finish() error {
+	acc := uint32(d.lastByte)&(1<<d.lastBits-1) | 3<<d.lastBits
+	n := (d.lastBits + 10 + 7) / 8
+	if d.lastBits > 0 {
+		d.padding += int64(n - 1)
+	} else {
+		d.padding += int64(n)
+	}
+	d.lastBits = 0
+
+	b := [3]byte{byte(acc), byte(acc >> 8), byte(acc >> 16)}
+	if _, err := d.w.Write(b[:n]); err != nil {
+		return fmt.Errorf("unable to output last block: %w", err)
+	}
+	return nil
+}
+
+func (d *deflateMerger) feed() {
+	if d.onFeed != nil {
+		d.onFeed()
+	}
+}
+
+// written returns the number of bytes written to the output.
+func (d *deflateMerger) written() int64 {
+	return d.cw.n + int64(d.w.Buffered())
+}
+
+// peekToBuf discards the consumed bytes from br, and then copies the following
+// bytes to buf without consuming them, thus whatever follows the deflate data
+// remains in br.
+func peekToBuf(stream *C.z_stream, br *bufio.Reader, buf *C.uchar, consumed int) (int, error) {
+	if _, err := br.Discard(consumed); err != nil {
+		return 0, fmt.Errorf("unable to skip consumed data: %w", err)
+	}
+	data, err := br.Peek(BufSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return 0, fmt.Errorf("unable to read to buf: %w", err)
+	}
+	if len(data) == 0 {
+		return 0, fmt.Errorf("unable to read deflate data: %w", io.ErrUnexpectedEOF)
+	}
+	readSize := copy(unsafe.Slice((*byte)(buf), BufSize), data)
+	stream.avail_in = C.uint(readSize)
+	stream.next_in = buf
+	return readSize, nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
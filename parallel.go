@@ -0,0 +1,188 @@
+package dfjoin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// WithParallel makes ConcatGzipWith, ConcatZlibWith and ConcatDeflateWith
+// inflate up to workers inputs concurrently, each worker splices an input into
+// memory ahead of time and the spliced data are written out in order. At most
+// inFlight inputs are held in memory at once. workers <= 0 means
+// runtime.GOMAXPROCS(0), and inFlight <= 0 means twice the workers. Progress is
+// only reported when an input is done.
+func WithParallel(workers, inFlight int) Option {
+	return func(c *joinConfig) {
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		if inFlight <= 0 {
+			inFlight = 2 * workers
+		}
+		c.workers = workers
+		c.inFlight = inFlight
+	}
+}
+
+// segment is an input spliced by a worker ahead of time.
+type segment struct {
+	data     []byte
+	lastByte byte
+	lastBits uint
+	stats    InputStats
+	modTime  uint32 // the newest modification time of the members of a gzip input
+	err      error
+}
+
+// splitSegment splices r into memory with the worker merger wm.
+func splitSegment(ctx context.Context, wm merger, r io.Reader) *segment {
+	d := wm.base()
+	buf := new(bytes.Buffer)
+	d.reset(buf)
+	d.in = &InputStats{}
+
+	err := spliceInput(ctx, wm, r)
+	d.in.CompressedBytes = d.pos()
+	if err == nil {
+		if err = d.w.Flush(); err != nil {
+			err = fmt.Errorf("unable to flush write buffer: %w", err)
+		}
+	}
+	seg := &segment{
+		data:     buf.Bytes(),
+		lastByte: d.lastByte,
+		lastBits: d.lastBits,
+		stats:    *d.in,
+		err:      err,
+	}
+	d.lastBits = 0
+	if gm, ok := wm.(*gzMerger); ok {
+		seg.modTime, gm.modTime = gm.modTime, 0
+	}
+	return seg
+}
+
+// ingest writes out the segment spliced by a worker as if it was appended.
+func (j *Joiner) ingest(seg *segment) error {
+	d := j.m.base()
+	if seg.err == nil {
+		if err := j.boundary(); err != nil {
+			return err
+		}
+	}
+	d.in = &seg.stats
+	if seg.err != nil {
+		return j.done(seg.err)
+	}
+
+	if err := j.mark(false); err != nil {
+		return j.done(err)
+	}
+	if _, err := d.w.Write(seg.data); err != nil {
+		return j.done(fmt.Errorf("unable to output: %w", err))
+	}
+	d.lastByte, d.lastBits = seg.lastByte, seg.lastBits
+	if gm, ok := j.m.(*gzMerger); ok && seg.modTime > gm.modTime {
+		gm.modTime = seg.modTime
+	}
+	j.m.combine(&seg.stats)
+	return j.done(nil)
+}
+
+func (j *Joiner) appendParallel(ctx context.Context, inputs []io.Reader) error {
+	if j.closed {
+		return errJoinerClosed
+	}
+	if j.err != nil {
+		return j.err
+	}
+	if len(inputs) > 0 {
+		first, err := j.header(inputs[0])
+		if err != nil {
+			return err
+		}
+		inputs = append([]io.Reader{first}, inputs[1:]...)
+		// the dictionary of the first input is only known to the main merger
+		if zm, ok := j.m.(*zlibMerger); ok && zm.dict != nil {
+			if err = j.AppendContext(ctx, inputs[0]); err != nil {
+				return err
+			}
+			inputs = inputs[1:]
+		}
+	}
+
+	// workers must be stopped before they are waited for
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type job struct {
+		r    io.Reader
+		done chan *segment
+	}
+
+	jobs := make(chan job)
+	results := make(chan chan *segment, j.cfg.inFlight)
+	slots := make(chan struct{}, j.cfg.inFlight)
+
+	go func() {
+		defer close(jobs)
+		defer close(results)
+		for _, r := range inputs {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			jb := job{r: r, done: make(chan *segment, 1)}
+			results <- jb.done
+			select {
+			case jobs <- jb:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < j.cfg.workers && i < len(inputs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wm, err := j.m.worker()
+			if err != nil {
+				for jb := range jobs {
+					jb.done <- &segment{err: err}
+				}
+				return
+			}
+			defer wm.Close()
+			for jb := range jobs {
+				jb.done <- splitSegment(ctx, wm, jb.r)
+			}
+		}()
+	}
+
+	for done := range results {
+		var seg *segment
+		select {
+		case seg = <-done:
+		case <-ctx.Done():
+			seg = &segment{err: ctx.Err()}
+		}
+		if err := j.ingest(seg); err != nil {
+			return err
+		}
+		<-slots
+	}
+	if err := ctx.Err(); err != nil {
+		// the inputs were not dispatched completely
+		return j.ingest(&segment{err: err})
+	}
+	return nil
+}
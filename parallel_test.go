@@ -0,0 +1,120 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelConcat(t *testing.T) {
+	inputs := genTestInputs(100)
+	inputs = append(inputs, bytes.Repeat(text4Test, 5000))
+
+	t.Run("gzip", func(t *testing.T) {
+		readers := make([]io.Reader, len(inputs))
+		for i, input := range inputs {
+			readers[i] = bytes.NewReader(gzCompress(input))
+		}
+
+		var stats JoinStats
+		joined := new(bytes.Buffer)
+		if err := ConcatGzipWith(joined, readers, WithParallel(4, 3), WithStats(&stats)); err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, stats.Inputs, len(inputs))
+		assert.Equal(t, int64(joined.Len()), stats.BytesWritten)
+		assert.Equal(t, bytes.Join(inputs, nil), readSingleMember(t, joined))
+	})
+
+	t.Run("zlib", func(t *testing.T) {
+		readers := make([]io.Reader, len(inputs))
+		for i, input := range inputs {
+			out := new(bytes.Buffer)
+			zw := zlib.NewWriter(out)
+			_, _ = zw.Write(input)
+			_ = zw.Close()
+			readers[i] = out
+		}
+
+		joined := new(bytes.Buffer)
+		if err := ConcatZlibWith(joined, readers, WithParallel(0, 0)); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zlib.NewReader(joined)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := io.ReadAll(zr)
+		assert.NoError(t, err)
+		assert.Equal(t, bytes.Join(inputs, nil), plain)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		readers := make([]io.Reader, len(inputs))
+		for i, input := range inputs {
+			readers[i] = bytes.NewReader(gzCompress(input))
+		}
+		readers[42] = bytes.NewReader(text4Test)
+
+		err := ConcatGzipWith(io.Discard, readers, WithParallel(4, 4))
+		var ce *ConcatError
+		if !errors.As(err, &ce) {
+			t.Fatalf("expect a ConcatError, got %v", err)
+		}
+		assert.Equal(t, 42, ce.Index)
+		assert.ErrorIs(t, err, gzip.ErrHeader)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		readers := make([]io.Reader, len(inputs))
+		for i, input := range inputs {
+			readers[i] = bytes.NewReader(gzCompress(input))
+		}
+		readers[10] = &cancelReader{Reader: readers[10], cancel: cancel}
+
+		j, err := NewGzipJoiner(io.Discard, WithParallel(2, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = j.appendAll(ctx, readers)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, j.Close(), context.Canceled)
+	})
+}
+
+func BenchmarkParallelConcatGzip(b *testing.B) {
+	inputs := make([][]byte, 64)
+	for i := range inputs {
+		inputs[i] = generateGzOut(1 << 20)
+	}
+	readers := make([]io.Reader, len(inputs))
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for k, input := range inputs {
+				readers[k] = bytes.NewReader(input)
+			}
+			if err := ConcatGzip(io.Discard, readers...); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for k, input := range inputs {
+				readers[k] = bytes.NewReader(input)
+			}
+			if err := ConcatGzipWith(io.Discard, readers, WithParallel(0, 0)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package dfjoin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+)
+
+/*
+#include "dfjoin.h"
+*/
+import "C"
+
+// PlainInput is an uncompressed input for the concat functions and the Joiner,
+// which compress it with zlib's deflate and splice it like the compressed
+// inputs, so plain and compressed inputs can be interleaved. Reading from a
+// PlainInput reads the uncompressed data.
+type PlainInput struct {
+	R        io.Reader
+	Level    int // from NoCompression to BestCompression, or DefaultCompression
+	Strategy int // DefaultStrategy, Filtered, HuffmanOnly, RLE or Fixed
+}
+
+// Plain returns a PlainInput of p compressed with DefaultCompression.
+func Plain(p []byte) *PlainInput {
+	return &PlainInput{R: bytes.NewReader(p), Level: DefaultCompression}
+}
+
+func (p *PlainInput) Read(b []byte) (int, error) {
+	return p.R.Read(b)
+}
+
+// spliceInput splices r with m, compressing it first if it's a PlainInput.
+func spliceInput(ctx context.Context, m merger, r io.Reader) error {
+	p, ok := r.(*PlainInput)
+	if !ok {
+		return m.concat(ctx, r)
+	}
+
+	d := m.base()
+	crc32Sum, adler32Sum, err := d.compress(ctx, p)
+	if err != nil {
+		return newConcatError(d.pos(), err)
+	}
+	m.checksum(d.in, crc32Sum, adler32Sum)
+	m.combine(d.in)
+	return nil
+}
+
+// compress deflates the data of p to the output, ending with a sync flush so
+// that the output stays byte aligned and without a last block.
+func (d *deflateMerger) compress(ctx context.Context, p *PlainInput) (crc32Sum, adler32Sum uint32, err error) {
+	br := d.open(p.R)
+	if err = d.align(); err != nil {
+		return 0, 0, err
+	}
+
+	z, err := newDeflater(p.Level, -15, 8, p.Strategy)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer z.Close()
+
+	crc32Checker := crc32.NewIEEE()
+	adler32Checker := adler32.New()
+	buf := make([]byte, BufSize)
+	for {
+		if err = ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+
+		n, rerr := br.Read(buf)
+		if rerr != nil && !errors.Is(rerr, io.EOF) {
+			return 0, 0, fmt.Errorf("unable to read plain data: %w", rerr)
+		}
+		_, _ = crc32Checker.Write(buf[:n])
+		_, _ = adler32Checker.Write(buf[:n])
+		d.in.UncompressedBytes += int64(n)
+		d.feed()
+
+		if rerr != nil {
+			if err = z.write(d.w, buf[:n], C.Z_SYNC_FLUSH); err != nil {
+				return 0, 0, err
+			}
+			return crc32Checker.Sum32(), adler32Checker.Sum32(), nil
+		}
+		if err = z.write(d.w, buf[:n], C.Z_NO_FLUSH); err != nil {
+			return 0, 0, err
+		}
+	}
+}
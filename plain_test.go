@@ -0,0 +1,106 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcatPlain(t *testing.T) {
+	inputs := genTestInputs(20)
+
+	var gzInputs, zlibInputs []io.Reader
+	for i, input := range inputs {
+		if i%2 == 0 {
+			gzInputs = append(gzInputs, bytes.NewReader(gzCompress(input)))
+			out := new(bytes.Buffer)
+			zw := zlib.NewWriter(out)
+			_, _ = zw.Write(input)
+			_ = zw.Close()
+			zlibInputs = append(zlibInputs, out)
+			continue
+		}
+		gzInputs = append(gzInputs, Plain(input))
+		zlibInputs = append(zlibInputs, &PlainInput{R: bytes.NewReader(input), Level: i % 10, Strategy: i % 5})
+	}
+	expected := bytes.Join(inputs, nil)
+
+	joined := new(bytes.Buffer)
+	if err := ConcatGzip(joined, gzInputs...); err != nil {
+		t.Fatal(err)
+	}
+	gr, err := gzip.NewReader(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, plain)
+
+	joined.Reset()
+	if err = ConcatZlibWith(joined, zlibInputs, WithParallel(4, 0)); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zlib.NewReader(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err = io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, plain)
+
+	err = ConcatGzip(io.Discard, &PlainInput{R: bytes.NewReader(text4Test), Level: 10})
+	var ce *ConcatError
+	if assert.ErrorAs(t, err, &ce) {
+		assert.Equal(t, 0, ce.Index)
+	}
+}
+
+func TestSeparator(t *testing.T) {
+	records := [][]byte{[]byte(`{"a":1}`), []byte(`{"b":2}`), []byte(`{"c":3}`)}
+	opts := []Option{WithPrefix([]byte("[")), WithSeparator([]byte(",")), WithSuffix([]byte("]"))}
+
+	for _, parallel := range []bool{false, true} {
+		var inputs []io.Reader
+		for _, record := range records {
+			inputs = append(inputs, bytes.NewReader(gzCompress(record)))
+		}
+		opts := opts
+		if parallel {
+			opts = append(opts, WithParallel(2, 0))
+		}
+
+		joined := new(bytes.Buffer)
+		if err := ConcatGzipWith(joined, inputs, opts...); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, `[{"a":1},{"b":2},{"c":3}]`, string(readSingleMember(t, joined)))
+	}
+
+	joined := new(bytes.Buffer)
+	j, err := NewZlibJoiner(joined, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zlib.NewReader(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "[]", string(plain))
+	assert.Empty(t, j.Stats().Inputs)
+}
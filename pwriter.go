@@ -0,0 +1,294 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+/*
+#include "dfjoin.h"
+*/
+import "C"
+
+// DefaultChunkSize is the size of the chunks compressed concurrently by a
+// ParallelGzipWriter, as pigz's default block size.
+const DefaultChunkSize = 128 << 10
+
+// WithChunkSize sets the size of the chunks of a ParallelGzipWriter, not above
+// 0 means DefaultChunkSize. It has no effect on the other writers.
+func WithChunkSize(size int) WriterOption {
+	return func(c *writerConfig) {
+		c.chunkSize = size
+	}
+}
+
+// ParallelGzipWriter compresses to a single gzip member with several goroutines,
+// the way pigz does: the input is cut in chunks compressed concurrently with
+// zlib's deflate, every chunk primed with the end of the data before it as
+// dictionary and ended by a sync flush, so that the chunks are put end to end,
+// and their CRC-32 are combined into the trailer. It has the Write, Flush and
+// Close methods and the Header fields of compress/gzip.Writer.
+type ParallelGzipWriter struct {
+	gzip.Header
+	w     io.Writer
+	level int
+	cfg   writerConfig
+
+	buf    []byte // input of the next chunk
+	window []byte // the last input data, up to the window size
+
+	jobs  chan *pchunk
+	queue chan *pchunk // chunks to write out, in order
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+
+	wroteHeader bool
+	closed      bool
+	crc32Sum    uint32 // of the chunks written out, updated by the output goroutine
+	size        uint32
+}
+
+// pchunk is a chunk of input compressed by a worker, or the header or a barrier
+// for the output goroutine, which are ready from the start.
+type pchunk struct {
+	data     []byte
+	dict     []byte
+	last     bool
+	out      bytes.Buffer
+	crc32Sum uint32
+	err      error
+	ready    chan struct{} // closed once out is filled in
+	ack      chan struct{} // closed once the chunk is written out, if not nil
+}
+
+// NewParallelGzipWriter returns a ParallelGzipWriter writing to w at level, from
+// NoCompression to BestCompression or DefaultCompression, with workers
+// goroutines, or runtime.NumCPU() if workers is not above 0.
+func NewParallelGzipWriter(w io.Writer, level, workers int, opts ...WriterOption) (*ParallelGzipWriter, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	pw := &ParallelGzipWriter{Header: gzip.Header{OS: 255}, w: w, level: level}
+	pw.cfg = writerConfig{strategy: DefaultStrategy, memLevel: 8, windowBits: 15}
+	for _, opt := range opts {
+		opt(&pw.cfg)
+	}
+	if pw.cfg.chunkSize <= 0 {
+		pw.cfg.chunkSize = DefaultChunkSize
+	}
+
+	deflaters := make([]*deflater, workers)
+	for i := range deflaters {
+		z, err := newDeflater(level, -pw.cfg.windowBits, pw.cfg.memLevel, pw.cfg.strategy)
+		if err != nil {
+			for _, z := range deflaters[:i] {
+				_ = z.Close()
+			}
+			return nil, err
+		}
+		deflaters[i] = z
+	}
+
+	pw.jobs = make(chan *pchunk)
+	pw.queue = make(chan *pchunk, 2*workers)
+	pw.done = make(chan struct{})
+	for _, z := range deflaters {
+		pw.wg.Add(1)
+		go pw.compress(z)
+	}
+	go pw.output()
+	return pw, nil
+}
+
+// compress compresses the chunks sent by the writer until it's closed.
+func (pw *ParallelGzipWriter) compress(z *deflater) {
+	defer pw.wg.Done()
+	defer z.Close()
+	for c := range pw.jobs {
+		c.err = z.compressChunk(&c.out, c.dict, c.data, c.last)
+		c.crc32Sum = crc32.ChecksumIEEE(c.data)
+		close(c.ready)
+	}
+}
+
+// compressChunk compresses p to w as the continuation of the data ending with
+// dict, with a sync flush, or as the end of the stream if last is true.
+func (z *deflater) compressChunk(w io.Writer, dict, p []byte, last bool) error {
+	if ret := C.deflateReset(z.stream); ret != C.Z_OK {
+		return fmt.Errorf("unable to reset deflate: %d", int(ret))
+	}
+	if len(dict) > 0 {
+		if ret := C.deflateSetDictionary(z.stream, (*C.Bytef)(unsafe.Pointer(&dict[0])), C.uInt(len(dict))); ret != C.Z_OK {
+			return fmt.Errorf("unable to set deflate dictionary: %d", int(ret))
+		}
+	}
+	flush := C.int(C.Z_SYNC_FLUSH)
+	if last {
+		flush = C.Z_FINISH
+	}
+	return z.write(w, p, flush)
+}
+
+// output writes out the chunks in order, and keeps waiting for them after an
+// error so that the writer never blocks.
+func (pw *ParallelGzipWriter) output() {
+	defer close(pw.done)
+	for c := range pw.queue {
+		<-c.ready
+		if pw.error() == nil {
+			err := c.err
+			if err == nil {
+				if _, err = pw.w.Write(c.out.Bytes()); err != nil {
+					err = fmt.Errorf("unable to output compressed data: %w", err)
+				}
+			}
+			if err != nil {
+				pw.setError(err)
+			}
+			if len(c.data) > 0 {
+				pw.crc32Sum = IEEECrc32Combine(pw.crc32Sum, c.crc32Sum, int64(len(c.data)))
+				pw.size += uint32(len(c.data))
+			}
+		}
+		if c.ack != nil {
+			close(c.ack)
+		}
+	}
+}
+
+func (pw *ParallelGzipWriter) error() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.err
+}
+
+func (pw *ParallelGzipWriter) setError(err error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.err == nil {
+		pw.err = err
+	}
+}
+
+// writeHeader queues the gzip header ahead of the first chunk.
+func (pw *ParallelGzipWriter) writeHeader() error {
+	if pw.wroteHeader {
+		return nil
+	}
+	pw.wroteHeader = true
+	header, err := appendGzipHeader(nil, &pw.Header, pw.level)
+	if err != nil {
+		err = fmt.Errorf("unable to output gzip header: %w", err)
+		pw.setError(err)
+		return err
+	}
+	c := &pchunk{ready: make(chan struct{})}
+	c.out.Write(header)
+	close(c.ready)
+	pw.queue <- c
+	return nil
+}
+
+// dispatch sends the buffered input to the workers as the next chunk.
+func (pw *ParallelGzipWriter) dispatch(last bool) {
+	c := &pchunk{data: pw.buf, dict: pw.window, last: last, ready: make(chan struct{})}
+	pw.queue <- c
+	pw.jobs <- c
+
+	// the chunks are never modified once dispatched, so the dictionaries may
+	// share their data
+	size := 1 << pw.cfg.windowBits
+	if len(pw.buf) >= size {
+		pw.window = pw.buf[len(pw.buf)-size:]
+	} else {
+		keep := size - len(pw.buf)
+		if keep > len(pw.window) {
+			keep = len(pw.window)
+		}
+		pw.window = append(append(make([]byte, 0, keep+len(pw.buf)), pw.window[len(pw.window)-keep:]...), pw.buf...)
+	}
+	pw.buf = make([]byte, 0, pw.cfg.chunkSize)
+}
+
+// Write compresses p, chunks are compressed as soon as they are filled up.
+func (pw *ParallelGzipWriter) Write(p []byte) (int, error) {
+	if pw.closed {
+		return 0, errWriterClosed
+	}
+	if err := pw.error(); err != nil {
+		return 0, err
+	}
+	if err := pw.writeHeader(); err != nil {
+		return 0, err
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		k := pw.cfg.chunkSize - len(pw.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		pw.buf = append(pw.buf, p[:k]...)
+		p = p[k:]
+		if len(pw.buf) == pw.cfg.chunkSize {
+			pw.dispatch(false)
+		}
+	}
+	return n, nil
+}
+
+// Flush compresses the buffered input as a chunk, and waits until every chunk
+// has been written out.
+func (pw *ParallelGzipWriter) Flush() error {
+	if pw.closed {
+		return errWriterClosed
+	}
+	if err := pw.writeHeader(); err != nil {
+		return err
+	}
+	if len(pw.buf) > 0 {
+		pw.dispatch(false)
+	}
+	c := &pchunk{ready: make(chan struct{}), ack: make(chan struct{})}
+	close(c.ready)
+	pw.queue <- c
+	<-c.ack
+	return pw.error()
+}
+
+// Close compresses the buffered input as the last chunk, waits for the workers
+// and writes the trailer. It doesn't close the underlying io.Writer.
+func (pw *ParallelGzipWriter) Close() error {
+	if pw.closed {
+		return pw.error()
+	}
+	pw.closed = true
+	if pw.writeHeader() == nil {
+		pw.dispatch(true)
+	}
+	close(pw.jobs)
+	close(pw.queue)
+	<-pw.done
+	pw.wg.Wait()
+
+	if err := pw.error(); err != nil {
+		return err
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:], pw.crc32Sum)
+	binary.LittleEndian.PutUint32(trailer[4:], pw.size)
+	if _, err := pw.w.Write(trailer[:]); err != nil {
+		pw.setError(fmt.Errorf("unable to output gzip trailer: %w", err))
+	}
+	return pw.error()
+}
@@ -0,0 +1,81 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelGzipWriter(t *testing.T) {
+	input := bytes.Join(genTestInputs(20), nil)
+
+	for _, tc := range []struct {
+		chunkSize  int
+		windowBits int
+		flush      bool
+	}{
+		{0, 15, false},
+		{1000, 15, true},
+		{50000, 9, false},
+		{4096, 12, true},
+	} {
+		out := new(bytes.Buffer)
+		pw, err := NewParallelGzipWriter(out, DefaultCompression, 4, WithChunkSize(tc.chunkSize), WithWindowBits(tc.windowBits))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pw.Name = "parallel.txt"
+		for p := input; len(p) > 0; {
+			n := 7777
+			if n > len(p) {
+				n = len(p)
+			}
+			_, err = pw.Write(p[:n])
+			assert.NoError(t, err)
+			p = p[n:]
+			if tc.flush {
+				assert.NoError(t, pw.Flush())
+			}
+		}
+		assert.NoError(t, pw.Close())
+		_, err = pw.Write(input)
+		assert.Error(t, err)
+
+		assert.Equal(t, input, readSingleMember(t, bytes.NewReader(out.Bytes())))
+		gr, err := gzip.NewReader(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "parallel.txt", gr.Name)
+	}
+}
+
+func TestParallelGzipWriterEmpty(t *testing.T) {
+	out := new(bytes.Buffer)
+	pw, err := NewParallelGzipWriter(out, BestSpeed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, pw.Close())
+	assert.Equal(t, []byte{}, readSingleMember(t, bytes.NewReader(out.Bytes())))
+}
+
+func BenchmarkParallelGzipWriter(b *testing.B) {
+	input := bytes.Join(genTestInputs(50), nil)
+	for _, workers := range []int{1, 4} {
+		b.Run(map[int]string{1: "1-worker", 4: "4-workers"}[workers], func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				pw, err := NewParallelGzipWriter(io.Discard, DefaultCompression, workers)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, _ = pw.Write(input)
+				_ = pw.Close()
+			}
+		})
+	}
+}
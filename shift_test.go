@@ -0,0 +1,162 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/gzip"
+	crand "crypto/rand"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	bits64 "math/bits"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// genShiftInputs returns inputs compressed at every level, stored blocks
+// included, some of them incompressible.
+func genShiftInputs(n int) ([][]byte, []io.Reader) {
+	inputs := genTestInputs(n)
+	readers := make([]io.Reader, n)
+	for i := range inputs {
+		if i%4 == 3 {
+			inputs[i] = make([]byte, rand.Intn(100000))
+			_, _ = crand.Read(inputs[i])
+		}
+		out := new(bytes.Buffer)
+		gw, _ := gzip.NewWriterLevel(out, i%10)
+		_, _ = gw.Write(inputs[i])
+		_ = gw.Close()
+		readers[i] = out
+	}
+	return inputs, readers
+}
+
+func TestBitShift(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		inputs, readers := genShiftInputs(60)
+
+		var stats JoinStats
+		var m Manifest
+		opts := []Option{WithBitShift(), WithStats(&stats), WithManifest(&m)}
+		if parallel {
+			opts = append(opts, WithParallel(4, 0))
+		}
+		joined := new(bytes.Buffer)
+		if err := ConcatGzipWith(joined, readers, opts...); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, bytes.Join(inputs, nil), readSingleMember(t, bytes.NewReader(joined.Bytes())))
+
+		ra := bytes.NewReader(joined.Bytes())
+		for i := range inputs {
+			sr, err := ExtractSegment(ra, &m, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(sr)
+			_ = sr.Close()
+			if err != nil {
+				t.Fatalf("segment %d, bit %d: %v", i, m.Segments[i].Bit, err)
+			}
+			assert.Equal(t, inputs[i], data)
+		}
+		if !parallel {
+			// only the last block is written by Close
+			assert.LessOrEqual(t, stats.PaddingBytes, int64(2))
+		}
+	}
+}
+
+// fixedGzip compresses every block of literals into a block with fixed codes, the
+// last one being the final block, so that where the blocks start is known.
+func fixedGzip(blocks ...[]byte) []byte {
+	out := bytes.NewBuffer(append([]byte(nil), simpleGzipHeader...))
+	var acc uint64
+	var n uint
+	put := func(code uint64, bits uint, reverse bool) {
+		if reverse {
+			// Huffman codes are packed starting from their most significant bit
+			code = bits64.Reverse64(code) >> (64 - bits)
+		}
+		acc |= code << n
+		for n += bits; n >= 8; n -= 8 {
+			out.WriteByte(byte(acc))
+			acc >>= 8
+		}
+	}
+	var all []byte
+	for i, block := range blocks {
+		if i == len(blocks)-1 {
+			put(3, 3, false)
+		} else {
+			put(2, 3, false)
+		}
+		for _, b := range block {
+			if b < 144 {
+				put(0x30+uint64(b), 8, true)
+			} else {
+				put(0x190+uint64(b)-144, 9, true)
+			}
+		}
+		put(0, 7, true)
+		all = append(all, block...)
+	}
+	if n > 0 {
+		out.WriteByte(byte(acc))
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(all))
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(len(all)))
+	out.Write(trailer[:])
+	return out.Bytes()
+}
+
+func TestBitShiftChunkBoundary(t *testing.T) {
+	// 18 bits, which leave 2 bits pending
+	first := fixedGzip([]byte("a"))
+	// the header of the second block starts at bit 6 of the last byte of the
+	// first chunk inflated, and runs over the next chunk: 3+8*32762+9*4+7 bits
+	block := append(bytes.Repeat([]byte("a"), BufSize-6), 200, 200, 200, 200)
+	second := fixedGzip(block, []byte("end"))
+
+	joined := new(bytes.Buffer)
+	inputs := []io.Reader{bytes.NewReader(first), bytes.NewReader(second)}
+	if err := ConcatGzipWith(joined, inputs, WithBitShift()); err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte("a"), block...), "end"...)
+	assert.Equal(t, want, readSingleMember(t, bytes.NewReader(joined.Bytes())))
+}
+
+func BenchmarkBitShift(b *testing.B) {
+	inputs := make([][]byte, 1000)
+	for i := range inputs {
+		inputs[i] = gzCompress([]byte("a small gzip record\n"))
+	}
+
+	for _, mode := range []struct {
+		name string
+		opts []Option
+	}{
+		{"align", nil},
+		{"bit-shift", []Option{WithBitShift()}},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			var written int64
+			for i := 0; i < b.N; i++ {
+				readers := make([]io.Reader, len(inputs))
+				for k, input := range inputs {
+					readers[k] = bytes.NewReader(input)
+				}
+				var stats JoinStats
+				if err := ConcatGzipWith(io.Discard, readers, append(mode.opts, WithStats(&stats))...); err != nil {
+					b.Fatal(err)
+				}
+				written = stats.BytesWritten
+			}
+			b.ReportMetric(float64(written), "bytes")
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package dfjoin
+
+// InputStats describes how an input has been spliced. The blocks of a member
+// spliced without inflating it, one written by a JoinReadyWriter, are counted
+// as two: its last block and the data before it, if any.
+type InputStats struct {
+	CompressedBytes   int64  // bytes consumed from the input, headers and trailers included
+	UncompressedBytes int64  // bytes of the decompressed input
+	Checksum          uint32 // CRC-32 of a gzip or raw deflate input, Adler-32 of a zlib input
+	Blocks            int    // number of deflate blocks, see below
+	PaddingBytes      int64  // bytes of empty blocks written before the input to align the output
+
+	adler32Sum uint32 // Adler-32 of a raw deflate input
+}
+
+// JoinStats describes what a Joiner did.
+type JoinStats struct {
+	Inputs       []InputStats
+	BytesWritten int64 // total bytes written to the output
+	PaddingBytes int64 // total bytes of empty blocks, including the last block written by Close
+}
+
+// Progress is reported to the callback set by WithProgress.
+type Progress struct {
+	Input             int   // index of the input being spliced
+	CompressedBytes   int64 // bytes of the input consumed so far
+	UncompressedBytes int64 // bytes of the input decompressed so far
+	Done              bool  // whether the input has been spliced completely
+}
+
+// WithStats makes the Joiner fill s with the statistics of the join when it's
+// closed, it's useful with ConcatGzipWith and ConcatZlibWith.
+func WithStats(s *JoinStats) Option {
+	return func(c *joinConfig) {
+		c.stats = s
+	}
+}
+
+// WithProgress makes the Joiner call fn every time another interval bytes of
+// compressed data are read from an input, and when an input is done. An interval
+// not above 0 only reports the completion of inputs.
+func WithProgress(interval int64, fn func(Progress)) Option {
+	return func(c *joinConfig) {
+		c.progressInterval = interval
+		c.progress = fn
+	}
+}
@@ -0,0 +1,83 @@
+package dfjoin
+
+import (
+	"bytes"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinStats(t *testing.T) {
+	inputs := genTestInputs(8)
+	inputs = append(inputs, bytes.Repeat(text4Test, 1000))
+
+	readers := make([]io.Reader, len(inputs))
+	sizes := make([]int, len(inputs))
+	for i, input := range inputs {
+		gz := gzCompress(input)
+		sizes[i] = len(gz)
+		readers[i] = bytes.NewReader(gz)
+	}
+
+	var (
+		stats    JoinStats
+		progress []Progress
+	)
+	joined := new(bytes.Buffer)
+	err := ConcatGzipWith(joined, readers, WithStats(&stats), WithProgress(1024, func(p Progress) {
+		progress = append(progress, p)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, int64(joined.Len()), stats.BytesWritten)
+	assert.Len(t, stats.Inputs, len(inputs))
+	padding := int64(0)
+	for i, in := range stats.Inputs {
+		assert.Equal(t, int64(sizes[i]), in.CompressedBytes)
+		assert.Equal(t, int64(len(inputs[i])), in.UncompressedBytes)
+		assert.Equal(t, crc32.ChecksumIEEE(inputs[i]), in.Checksum)
+		assert.GreaterOrEqual(t, in.Blocks, 1)
+		padding += in.PaddingBytes
+	}
+	assert.GreaterOrEqual(t, stats.PaddingBytes, padding)
+
+	done := 0
+	for _, p := range progress {
+		if p.Done {
+			assert.Equal(t, done, p.Input)
+			done++
+		}
+	}
+	assert.Equal(t, len(inputs), done)
+	assert.Greater(t, len(progress), len(inputs))
+
+	t.Run("zlib", func(t *testing.T) {
+		zl := generateZlibOut(1 << 16)
+		zr, err := NewZlibReader(bytes.NewReader(zl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := io.ReadAll(zr)
+		_ = zr.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		j, err := NewZlibJoiner(io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = j.Append(bytes.NewReader(zl)); err != nil {
+			t.Fatal(err)
+		}
+		stats := j.Stats()
+		assert.NoError(t, j.Close())
+		assert.Equal(t, adler32.Checksum(plain), stats.Inputs[0].Checksum)
+		assert.Equal(t, int64(len(plain)), stats.Inputs[0].UncompressedBytes)
+	})
+}
@@ -0,0 +1,337 @@
+package dfjoin
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+/*
+#include "dfjoin.h"
+*/
+import "C"
+
+var errWriterClosed = errors.New("dfjoin: writer is closed")
+
+// WriterOption configures a GzipWriter, a ZlibWriter, a DeflateWriter or a
+// ParallelGzipWriter.
+type WriterOption func(*writerConfig)
+
+type writerConfig struct {
+	strategy   int
+	memLevel   int
+	windowBits int
+	chunkSize  int
+}
+
+// WithStrategy sets the compression strategy, DefaultStrategy, Filtered,
+// HuffmanOnly, RLE or Fixed.
+func WithStrategy(strategy int) WriterOption {
+	return func(c *writerConfig) {
+		c.strategy = strategy
+	}
+}
+
+// WithMemLevel sets how much memory deflate uses for its internal state, from 1
+// to 9, 8 by default.
+func WithMemLevel(memLevel int) WriterOption {
+	return func(c *writerConfig) {
+		c.memLevel = memLevel
+	}
+}
+
+// WithWindowBits sets the base two logarithm of the history window size, from 9
+// to 15, 15 by default. Smaller windows use less memory and compress worse.
+func WithWindowBits(windowBits int) WriterOption {
+	return func(c *writerConfig) {
+		c.windowBits = windowBits
+	}
+}
+
+// compressor is the part of the writers which drives zlib's deflate, its
+// exported methods are those of ZlibWriter and DeflateWriter.
+type compressor struct {
+	w     io.Writer
+	z     *deflater // nil once closed
+	level int
+	cfg   writerConfig
+	raw   bool // whether the zlib wrapper is left out
+	err   error
+}
+
+func (c *compressor) init(w io.Writer, level int, raw bool, opts []WriterOption) error {
+	c.w, c.level, c.raw = w, level, raw
+	c.cfg = writerConfig{strategy: DefaultStrategy, memLevel: 8, windowBits: 15}
+	for _, opt := range opts {
+		opt(&c.cfg)
+	}
+	return c.open()
+}
+
+func (c *compressor) open() error {
+	windowBits := c.cfg.windowBits
+	if c.raw {
+		windowBits = -windowBits
+	}
+	z, err := newDeflater(c.level, windowBits, c.cfg.memLevel, c.cfg.strategy)
+	if err != nil {
+		return err
+	}
+	c.z = z
+	return nil
+}
+
+// deflate compresses p to the output, see deflater.write.
+func (c *compressor) deflate(p []byte, flush C.int) error {
+	if c.z == nil {
+		return errWriterClosed
+	}
+	if c.err != nil {
+		return c.err
+	}
+	c.err = c.z.write(c.w, p, flush)
+	return c.err
+}
+
+// Write compresses p.
+func (c *compressor) Write(p []byte) (int, error) {
+	if err := c.deflate(p, C.Z_NO_FLUSH); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes out the pending compressed data followed by an empty stored
+// block, Z_SYNC_FLUSH in zlib's terms, so that everything written so far can be
+// decompressed.
+func (c *compressor) Flush() error {
+	return c.deflate(nil, C.Z_SYNC_FLUSH)
+}
+
+// FullFlush is like Flush but resets the compression state as well,
+// Z_FULL_FLUSH in zlib's terms, so that decompression can restart from there.
+func (c *compressor) FullFlush() error {
+	return c.deflate(nil, C.Z_FULL_FLUSH)
+}
+
+// Close ends the compressed stream and frees the zlib state, it doesn't close
+// the underlying io.Writer.
+func (c *compressor) Close() error {
+	if c.z == nil {
+		return c.err
+	}
+	err := c.deflate(nil, C.Z_FINISH)
+	c.close()
+	return err
+}
+
+func (c *compressor) close() {
+	_ = c.z.Close()
+	c.z = nil
+}
+
+// Reset discards the state of the writer and makes it write a new stream to w,
+// with the same settings. The zlib state is reused unless the writer has been
+// closed, an error allocating a new one is returned by the next call.
+func (c *compressor) Reset(w io.Writer) {
+	c.w, c.err = w, nil
+	if c.z == nil {
+		c.err = c.open()
+		return
+	}
+	if ret := C.deflateReset(c.z.stream); ret != C.Z_OK {
+		c.err = fmt.Errorf("unable to reset deflate: %d", int(ret))
+	}
+}
+
+// ZlibWriter compresses to a zlib stream with zlib's deflate, it has the method
+// set of compress/zlib.Writer.
+type ZlibWriter struct {
+	compressor
+}
+
+// NewZlibWriter returns a ZlibWriter writing to w at level, from NoCompression
+// to BestCompression or DefaultCompression.
+func NewZlibWriter(w io.Writer, level int, opts ...WriterOption) (*ZlibWriter, error) {
+	zw := &ZlibWriter{}
+	if err := zw.init(w, level, false, opts); err != nil {
+		return nil, err
+	}
+	return zw, nil
+}
+
+// DeflateWriter compresses to a raw deflate stream with zlib's deflate, it has
+// the method set of compress/flate.Writer.
+type DeflateWriter struct {
+	compressor
+}
+
+// NewDeflateWriter returns a DeflateWriter writing to w at level, from
+// NoCompression to BestCompression or DefaultCompression.
+func NewDeflateWriter(w io.Writer, level int, opts ...WriterOption) (*DeflateWriter, error) {
+	dw := &DeflateWriter{}
+	if err := dw.init(w, level, true, opts); err != nil {
+		return nil, err
+	}
+	return dw, nil
+}
+
+// GzipWriter compresses to a gzip stream with zlib's deflate, it has the method
+// set of compress/gzip.Writer. The Header fields are written along with the
+// first Write, Flush or Close.
+type GzipWriter struct {
+	gzip.Header
+	compressor
+	wroteHeader bool
+	crc32Sum    uint32
+	size        uint32
+}
+
+// NewGzipWriter returns a GzipWriter writing to w at level, from NoCompression
+// to BestCompression or DefaultCompression.
+func NewGzipWriter(w io.Writer, level int, opts ...WriterOption) (*GzipWriter, error) {
+	gw := &GzipWriter{Header: gzip.Header{OS: 255}}
+	if err := gw.init(w, level, true, opts); err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+func (gw *GzipWriter) writeHeader() error {
+	if gw.wroteHeader {
+		return nil
+	}
+	if gw.z == nil {
+		return errWriterClosed
+	}
+	if gw.err != nil {
+		return gw.err
+	}
+	gw.wroteHeader = true
+	header, err := appendGzipHeader(nil, &gw.Header, gw.level)
+	if err == nil {
+		_, err = gw.w.Write(header)
+	}
+	if err != nil {
+		gw.err = fmt.Errorf("unable to output gzip header: %w", err)
+	}
+	return gw.err
+}
+
+// Write compresses p.
+func (gw *GzipWriter) Write(p []byte) (int, error) {
+	if err := gw.writeHeader(); err != nil {
+		return 0, err
+	}
+	if err := gw.deflate(p, C.Z_NO_FLUSH); err != nil {
+		return 0, err
+	}
+	gw.crc32Sum = crc32.Update(gw.crc32Sum, crc32.IEEETable, p)
+	gw.size += uint32(len(p))
+	return len(p), nil
+}
+
+// Flush writes out the pending compressed data with a sync flush, see
+// ZlibWriter.Flush.
+func (gw *GzipWriter) Flush() error {
+	if err := gw.writeHeader(); err != nil {
+		return err
+	}
+	return gw.deflate(nil, C.Z_SYNC_FLUSH)
+}
+
+// FullFlush writes out the pending compressed data with a full flush, see
+// ZlibWriter.FullFlush.
+func (gw *GzipWriter) FullFlush() error {
+	if err := gw.writeHeader(); err != nil {
+		return err
+	}
+	return gw.deflate(nil, C.Z_FULL_FLUSH)
+}
+
+// Close ends the gzip stream with its trailer and frees the zlib state, it
+// doesn't close the underlying io.Writer.
+func (gw *GzipWriter) Close() error {
+	if gw.z == nil {
+		return gw.err
+	}
+	defer gw.close()
+	if err := gw.writeHeader(); err != nil {
+		return err
+	}
+	if err := gw.deflate(nil, C.Z_FINISH); err != nil {
+		return err
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:], gw.crc32Sum)
+	binary.LittleEndian.PutUint32(trailer[4:], gw.size)
+	if _, err := gw.w.Write(trailer[:]); err != nil {
+		gw.err = fmt.Errorf("unable to output gzip trailer: %w", err)
+	}
+	return gw.err
+}
+
+// Reset discards the state of the writer and makes it write a new gzip stream to
+// w, with the same settings and a zero Header, see ZlibWriter.Reset.
+func (gw *GzipWriter) Reset(w io.Writer) {
+	gw.compressor.Reset(w)
+	gw.Header = gzip.Header{OS: 255}
+	gw.wroteHeader = false
+	gw.crc32Sum, gw.size = 0, 0
+}
+
+// appendGzipHeader appends to b the gzip member header holding the fields of
+// hdr, the way compress/gzip writes them.
+func appendGzipHeader(b []byte, hdr *gzip.Header, level int) ([]byte, error) {
+	var fixed [10]byte
+	copy(fixed[:], simpleGzipHeader)
+	fixed[9] = hdr.OS
+	if hdr.ModTime.After(time.Unix(0, 0)) {
+		binary.LittleEndian.PutUint32(fixed[4:8], uint32(hdr.ModTime.Unix()))
+	}
+	switch level {
+	case BestCompression:
+		fixed[8] = 2
+	case BestSpeed:
+		fixed[8] = 4
+	}
+
+	var rest []byte
+	if hdr.Extra != nil {
+		if len(hdr.Extra) > 0xffff {
+			return nil, fmt.Errorf("extra field too long")
+		}
+		fixed[3] |= 4
+		rest = append(rest, byte(len(hdr.Extra)), byte(len(hdr.Extra)>>8))
+		rest = append(rest, hdr.Extra...)
+	}
+	var err error
+	if hdr.Name != "" {
+		fixed[3] |= 8
+		if rest, err = appendGzipString(rest, hdr.Name); err != nil {
+			return nil, err
+		}
+	}
+	if hdr.Comment != "" {
+		fixed[3] |= 16
+		if rest, err = appendGzipString(rest, hdr.Comment); err != nil {
+			return nil, err
+		}
+	}
+	return append(append(b, fixed[:]...), rest...), nil
+}
+
+// appendGzipString appends s converted to ISO 8859-1 and NUL terminated.
+func appendGzipString(b []byte, s string) ([]byte, error) {
+	for _, r := range s {
+		if r == 0 || r > 0xff {
+			return nil, fmt.Errorf("non-Latin-1 header string: %q", s)
+		}
+		b = append(b, byte(r))
+	}
+	return append(b, 0), nil
+}
@@ -0,0 +1,145 @@
+package dfjoin
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGzipWriter(t *testing.T) {
+	input := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1000)
+	modTime := time.Unix(1700000000, 0)
+
+	for _, strategy := range []int{DefaultStrategy, Filtered, HuffmanOnly, RLE, Fixed} {
+		for level := DefaultCompression; level <= BestCompression; level++ {
+			out := new(bytes.Buffer)
+			gw, err := NewGzipWriter(out, level, WithStrategy(strategy), WithMemLevel(9), WithWindowBits(12))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gw.Name, gw.Comment, gw.Extra, gw.ModTime = "café.txt", "a comment", []byte("extra"), modTime
+			_, _ = gw.Write(input[:100])
+			assert.NoError(t, gw.Flush())
+			_, _ = gw.Write(input[100:200])
+			assert.NoError(t, gw.FullFlush())
+			_, err = gw.Write(input[200:])
+			assert.NoError(t, err)
+			assert.NoError(t, gw.Close())
+			_, err = gw.Write(input)
+			assert.Error(t, err)
+
+			gr, err := gzip.NewReader(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(gr)
+			assert.NoError(t, err)
+			assert.Equal(t, input, data)
+			assert.Equal(t, "café.txt", gr.Name)
+			assert.Equal(t, "a comment", gr.Comment)
+			assert.Equal(t, []byte("extra"), gr.Extra)
+			assert.Equal(t, modTime, gr.ModTime)
+
+			// Reset after Close, and before
+			for i := 0; i < 2; i++ {
+				out.Reset()
+				gw.Reset(out)
+				assert.Equal(t, gzip.Header{OS: 255}, gw.Header)
+				_, _ = gw.Write(input)
+			}
+			assert.NoError(t, gw.Close())
+			assert.Equal(t, input, readSingleMember(t, bytes.NewReader(out.Bytes())))
+		}
+	}
+}
+
+func TestGzipWriterHeader(t *testing.T) {
+	gw, err := NewGzipWriter(io.Discard, DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw.Name = "日本"
+	_, err = gw.Write([]byte("data"))
+	assert.Error(t, err)
+	assert.Error(t, gw.Close())
+
+	_, err = NewGzipWriter(io.Discard, 10)
+	assert.Error(t, err)
+}
+
+func TestZlibDeflateWriter(t *testing.T) {
+	input := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1000)
+
+	zout := new(bytes.Buffer)
+	zw, err := NewZlibWriter(zout, BestSpeed, WithWindowBits(9), WithMemLevel(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dout := new(bytes.Buffer)
+	dw, err := NewDeflateWriter(dout, BestCompression, WithStrategy(RLE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []interface {
+		io.WriteCloser
+		Flush() error
+		Reset(io.Writer)
+	}{zw, dw} {
+		_, _ = w.Write(input[:1000])
+		assert.NoError(t, w.Flush())
+		_, _ = w.Write(input[1000:])
+		assert.NoError(t, w.Close())
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(zout.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, input, data)
+
+	data, err = io.ReadAll(flate.NewReader(bytes.NewReader(dout.Bytes())))
+	assert.NoError(t, err)
+	assert.Equal(t, input, data)
+
+	// the joiners splice what the writers produce
+	joined := new(bytes.Buffer)
+	assert.NoError(t, ConcatZlib(joined, bytes.NewReader(zout.Bytes()), bytes.NewReader(zout.Bytes())))
+	zr, err = zlib.NewReader(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, append(append([]byte(nil), input...), input...), data)
+}
+
+func BenchmarkGzipWriter(b *testing.B) {
+	input := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1<<14)
+	for _, impl := range []struct {
+		name string
+		new  func(w io.Writer) (io.WriteCloser, error)
+	}{
+		{"compress-gzip", func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }},
+		{"zlib", func(w io.Writer) (io.WriteCloser, error) { return NewGzipWriter(w, DefaultCompression) }},
+	} {
+		b.Run(impl.name, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				w, err := impl.new(io.Discard)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, _ = w.Write(input)
+				_ = w.Close()
+			}
+		})
+	}
+}
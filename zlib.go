@@ -2,7 +2,7 @@ package dfjoin
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -20,6 +20,20 @@ import "C"
 
 var simpleZlibHeader = []byte{0x78, 0x9c}
 
+// the header of a zlib stream with FDICT set, followed by the DICTID
+var zlibDictHeader = []byte{0x78, 0xbb}
+
+// WithZlibDictionary makes a zlib Joiner accept a first input needing a preset
+// dictionary, which lookup returns given its DICTID, the Adler-32 of the
+// dictionary. The output needs the same dictionary then, its header carries the
+// DICTID. Other inputs needing a dictionary fail with ErrZlibDict, as they do
+// without this option, as well as a first input following WithPrefix.
+func WithZlibDictionary(lookup func(dictID uint32) ([]byte, error)) Option {
+	return func(c *joinConfig) {
+		c.zlibDict = lookup
+	}
+}
+
 // #define Z_OK            0
 // #define Z_STREAM_END    1
 // #define Z_NEED_DICT     2
@@ -45,17 +59,28 @@ type inflater struct {
 	outputBuf      *C.uchar
 	inputAvailSize int
 	offset         int
-	br             *bufio.Reader
+	r              io.Reader
+	br             *bufio.Reader // r itself if it's a *bufio.Reader, or else buf
+	buf            *bufio.Reader
+	n              int64 // bytes consumed from br but those fed to inflate
 	lastBlock      bool
 	inflateEnd     bool
+	ignoreLast     bool // the end of data is known from elsewhere, see ExtractSegment
 }
 
+// feedIn copies the next input to the C buffer once inflate has consumed the
+// previous one, the input is discarded from br only once consumed so that br is
+// positioned right after the deflate data at the end, see sync.
 func (z *inflater) feedIn() error {
-	var err error
-	z.inputAvailSize, err = io.ReadFull(z.br, unsafe.Slice((*byte)(z.inputBuf), BufSize))
-	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+	if _, err := z.br.Discard(z.inputAvailSize); err != nil {
+		return fmt.Errorf("unable to skip consumed data: %w", err)
+	}
+	z.n += int64(z.inputAvailSize)
+	data, err := z.br.Peek(BufSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
 		return fmt.Errorf("unable to read: %w", err)
 	}
+	z.inputAvailSize = copy(unsafe.Slice((*byte)(z.inputBuf), BufSize), data)
 	if z.inputAvailSize == 0 {
 		return io.ErrUnexpectedEOF
 	}
@@ -79,11 +104,11 @@ func (z *inflater) inflate() (err error) {
 	//fmt.Printf("%T, %T\n", stream.avail_in, stream.next_in)
 	ret := C.inflate(z.stream, C.Z_BLOCK)
 
-	if errCode, ok := inflateErrors[int(ret)]; ok {
-		return fmt.Errorf("unable to inflate, error code: %d(%s)", int(ret), errCode)
+	if _, ok := inflateErrors[int(ret)]; ok {
+		return newInflateError(ret, z.stream)
 	}
 
-	if z.stream.data_type&C.int(128) != 0 {
+	if z.stream.data_type&C.int(128) != 0 && !z.ignoreLast {
 		if z.lastBlock {
 			z.inflateEnd = true
 			return nil
@@ -105,96 +130,289 @@ func (z *inflater) inflate() (err error) {
 	return nil
 }
 
+func (z *inflater) init(r io.Reader) error {
+	z.stream = new(C.z_stream)
+	if ret := C.initStream(z.stream); ret != C.Z_OK {
+		return fmt.Errorf("unable to init z_stream: %d", int(ret))
+	}
+
+	inBuf := C.malloc(BufSize)
+	outBuf := C.malloc(BufSize)
+
+	if inBuf == nil || outBuf == nil {
+		C.inflateEnd(z.stream)
+		if inBuf != nil {
+			C.free(inBuf)
+		}
+		if outBuf != nil {
+			C.free(outBuf)
+		}
+		errMessage := C.errMessage()
+		return fmt.Errorf("unable to malloc buffer memory: %s",
+			internal.UnsafeString((*byte)(unsafe.Pointer(errMessage)), int(C.strlen(errMessage))))
+	}
+
+	z.inputBuf = (*C.uchar)(inBuf)
+	z.outputBuf = (*C.uchar)(outBuf)
+	z.stream.avail_out = BufSize
+	z.stream.next_out = z.outputBuf
+	z.inputAvailSize, z.offset = 0, 0
+	z.lastBlock, z.inflateEnd = false, false
+	z.setInput(r)
+	return nil
+}
+
+// reset makes the inflater read a new stream from r, reusing its buffers.
+func (z *inflater) reset(r io.Reader) error {
+	z.setInput(r)
+	return z.restart()
+}
+
+// setInput makes r the input, which is read directly if it's a *bufio.Reader so
+// that it isn't read past the data consumed, as compress/flate reads an
+// io.ByteReader.
+func (z *inflater) setInput(r io.Reader) {
+	z.r, z.n, z.inputAvailSize = r, 0, 0
+	if br, ok := r.(*bufio.Reader); ok {
+		z.br = br
+		return
+	}
+	if z.buf == nil {
+		z.buf = bufio.NewReaderSize(r, BufSize)
+	} else {
+		z.buf.Reset(r)
+	}
+	z.br = z.buf
+}
+
+// unread seeks the input back by the bytes buffered ahead of the data consumed,
+// if it's an io.Seeker which isn't read directly. br must have been synced.
+func (z *inflater) unread() error {
+	s, ok := z.r.(io.Seeker)
+	if !ok || z.br != z.buf || z.br.Buffered() == 0 {
+		return nil
+	}
+	if _, err := s.Seek(-int64(z.br.Buffered()), io.SeekCurrent); err != nil {
+		return fmt.Errorf("unable to seek back the input: %w", err)
+	}
+	z.buf.Reset(z.r)
+	return nil
+}
+
+// restart makes the inflater read a new deflate stream from br, which must have
+// been synced.
+func (z *inflater) restart() error {
+	if ret := C.inflateReset(z.stream); ret != C.Z_OK {
+		return fmt.Errorf("unable to reset z_stream: %d", int(ret))
+	}
+	z.stream.avail_in = 0
+	z.stream.avail_out = BufSize
+	z.stream.next_out = z.outputBuf
+	z.offset = 0
+	z.lastBlock, z.inflateEnd = false, false
+	return nil
+}
+
+// sync discards from br the input consumed by inflate, once read has returned
+// io.EOF br is positioned right after the end of the deflate data.
+func (z *inflater) sync() error {
+	if _, err := z.br.Discard(z.inputAvailSize - int(z.stream.avail_in)); err != nil {
+		return fmt.Errorf("unable to skip consumed data: %w", err)
+	}
+	z.n += int64(z.inputAvailSize - int(z.stream.avail_in))
+	z.inputAvailSize, z.stream.avail_in = 0, 0
+	return nil
+}
+
+// read copies the inflated data to p, it returns io.EOF at the end of the last
+// deflate block.
+func (z *inflater) read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if z.offset > 0 && z.offset >= int(BufSize-z.stream.avail_out) {
+			z.offset = 0
+			z.stream.next_out = z.outputBuf
+			z.stream.avail_out = BufSize
+		}
+
+		for !z.inflateEnd && z.offset >= int(BufSize-z.stream.avail_out) {
+			if err = z.inflate(); err != nil {
+				return 0, fmt.Errorf("inflate: %w", err)
+			}
+		}
+
+		if z.offset >= int(BufSize-z.stream.avail_out) {
+			if n == 0 {
+				return 0, io.EOF
+			}
+			return n, nil
+		}
+
+		uncompressed := unsafe.Slice((*byte)(z.outputBuf), int(BufSize-z.stream.avail_out))
+		copied := copy(p[n:], uncompressed[z.offset:])
+		n += copied
+		z.offset += copied
+	}
+	return
+}
+
+// concatError returns err as a *ConcatError positioned at the end of the
+// compressed data consumed by inflate.
+func (z *inflater) concatError(index int, err error) error {
+	ce := newConcatError(z.n+int64(z.inputAvailSize)-int64(z.stream.avail_in), err)
+	ce.Index = index
+	return ce
+}
+
 func (z *inflater) Close() error {
-	if ret := C.inflateEnd(z.stream); ret != C.Z_OK {
-		return fmt.Errorf("unable to free z_stream: %v\n", ret)
+	if z.stream == nil {
+		return nil
 	}
+	ret := C.inflateEnd(z.stream)
 	C.free(unsafe.Pointer(z.inputBuf))
 	C.free(unsafe.Pointer(z.outputBuf))
+	z.stream, z.inputBuf, z.outputBuf = nil, nil, nil
+	if ret != C.Z_OK {
+		return fmt.Errorf("unable to free z_stream: %v\n", ret)
+	}
 	return nil
 }
 
 func ConcatZlib(w io.Writer, inputs ...io.Reader) error {
+	return concatZlib(context.Background(), w, inputs, nil)
+}
+
+// ConcatZlibWith is like ConcatZlib but takes options.
+func ConcatZlibWith(w io.Writer, inputs []io.Reader, opts ...Option) error {
+	return concatZlib(context.Background(), w, inputs, opts)
+}
+
+// ConcatZlibContext is like ConcatZlib but stops inflating as soon as ctx is done.
+func ConcatZlibContext(ctx context.Context, w io.Writer, inputs ...io.Reader) error {
+	return concatZlib(ctx, w, inputs, nil)
+}
+
+func concatZlib(ctx context.Context, w io.Writer, inputs []io.Reader, opts []Option) error {
 	if len(inputs) == 0 {
 		return fmt.Errorf("empty sources")
 	}
 
-	switch len(inputs) {
-	case 0:
-		return fmt.Errorf("empty sources")
-	case 1:
-		_, err := io.Copy(w, inputs[0])
-		return err
-	default:
-		zm, err := newZlibMerger(w)
-		if err != nil {
-			return fmt.Errorf("unable to write zlib header: %w", err)
-		}
-		defer zm.Close()
-		for i, r := range inputs {
-			if err = zm.concat(r, i == len(inputs)-1); err != nil {
-				return fmt.Errorf("unable to concat zlib: %w", err)
-			}
-		}
+	j, err := NewZlibJoiner(w, opts...)
+	if err != nil {
+		return fmt.Errorf("unable to write zlib header: %w", err)
 	}
-	return nil
+	if err = j.appendAll(ctx, inputs); err != nil {
+		_ = j.Close()
+		return fmt.Errorf("unable to concat zlib: %w", err)
+	}
+	return j.Close()
 }
 
 type zlibReader struct {
 	inflater
 	adler32 hash.Hash32
+	eof     bool // whether the trailer has been checked
 }
 
-func readZlibHeader(br *bufio.Reader) (n int, err error) {
+// readZlibHeader reads a zlib header from br, fdict reports whether the stream
+// needs the preset dictionary whose Adler-32 is dictID.
+func readZlibHeader(br *bufio.Reader) (n int, dictID uint32, fdict bool, err error) {
 	cmf, err := br.ReadByte()
 	if err != nil {
-		return n, fmt.Errorf("unable to read CMF byte: %w", err)
+		return n, 0, false, fmt.Errorf("unable to read CMF byte: %w", err)
 	}
 	n++
 	if cmf&0x0f != 8 {
-		return n, fmt.Errorf("only support deflate compression method(8), got %d", cmf&0x0f)
+		return n, 0, false, fmt.Errorf("only support deflate compression method(8), got %d", cmf&0x0f)
 	}
 	if cmf>>4 > 7 {
-		return n, fmt.Errorf("value of CINFO above 7 is not allowed")
+		return n, 0, false, fmt.Errorf("value of CINFO above 7 is not allowed")
 	}
 
 	flags, err := br.ReadByte()
 	if err != nil {
-		return n, fmt.Errorf("unable to read flags byte: %w", err)
+		return n, 0, false, fmt.Errorf("unable to read flags byte: %w", err)
 	}
 	n++
 
 	if (uint16(cmf)<<8|uint16(flags))%31 != 0 {
-		return n, fmt.Errorf("malformed FCHECK")
+		return n, 0, false, fmt.Errorf("malformed FCHECK")
 	}
 
 	if flags&0x20 != 0 {
-		discarded, err := br.Discard(4)
+		var id [4]byte
+		read, err := io.ReadFull(br, id[:])
+		n += read
 		if err != nil {
-			return n, fmt.Errorf("unable to read DICT checksum: %w", err)
+			return n, 0, false, fmt.Errorf("unable to read DICT checksum: %w", err)
 		}
-		n += discarded
+		return n, binary.BigEndian.Uint32(id[:]), true, nil
+	}
+
+	return n, 0, false, nil
+}
+
+// zlibDictionary returns the preset dictionary dictID given by lookup, checked
+// against its Adler-32.
+func zlibDictionary(lookup func(dictID uint32) ([]byte, error), dictID uint32) ([]byte, error) {
+	if lookup == nil {
+		return nil, fmt.Errorf("%w: preset dictionary 0x%08x required", ErrZlibDict, dictID)
+	}
+	dict, err := lookup(dictID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up dictionary 0x%08x: %w", dictID, err)
+	}
+	if sum := adler32.Checksum(dict); sum != dictID {
+		return nil, fmt.Errorf("%w: expect 0x%08x, got 0x%08x", ErrZlibDict, dictID, sum)
 	}
+	return dict, nil
+}
 
-	return n, nil
+// setDictionary primes the raw inflate stream with the preset dictionary dict.
+func setDictionary(stream *C.z_stream, dict []byte) error {
+	if len(dict) == 0 {
+		return nil
+	}
+	if ret := C.inflateSetDictionary(stream, (*C.Bytef)(unsafe.Pointer(&dict[0])), C.uInt(len(dict))); ret != C.Z_OK {
+		return fmt.Errorf("unable to set inflate dictionary: %d", int(ret))
+	}
+	return nil
 }
 
-func (z *zlibReader) readHeader() (n int, err error) {
-	return readZlibHeader(z.br)
+func (z *zlibReader) readHeader(lookup func(dictID uint32) ([]byte, error)) (n int, err error) {
+	n, dictID, fdict, err := readZlibHeader(z.br)
+	z.n += int64(n)
+	if err != nil || !fdict {
+		return n, err
+	}
+	dict, err := zlibDictionary(lookup, dictID)
+	if err != nil {
+		return n, err
+	}
+	return n, setDictionary(z.stream, dict)
 }
 
 func (z *zlibReader) Read(p []byte) (n int, err error) {
+	if z.eof {
+		return 0, io.EOF
+	}
+	defer func() {
+		if err != nil && err != io.EOF {
+			err = z.concatError(0, err)
+		}
+	}()
 	defer func() {
 		if n > 0 {
 			_, _ = z.adler32.Write(p[:n]) // adler32.Write always return nil error
 		}
 		if errors.Is(err, io.EOF) {
-			unRead := io.MultiReader(
-				bytes.NewReader(unsafe.Slice((*byte)(z.stream.next_in), int(z.stream.avail_in))),
-				z.br,
-			)
+			if ex := z.sync(); ex != nil {
+				err = ex
+				return
+			}
 			checksumBytes := make([]byte, 4)
-			if _, ex := io.ReadFull(unRead, checksumBytes); ex != nil {
+			read, ex := io.ReadFull(z.br, checksumBytes)
+			z.n += int64(read)
+			if ex != nil {
 				err = fmt.Errorf("unable to read zlib trailer: %w", ex)
 				return
 			}
@@ -204,293 +422,200 @@ func (z *zlibReader) Read(p []byte) (n int, err error) {
 				err = fmt.Errorf("%w: expect 0x%x, got 0x%x", ErrZlibSum, z.adler32, adler32Sum)
 				return
 			}
+			z.eof = true
 		}
 	}()
 
-	for n < len(p) {
-		if z.offset > 0 && z.offset >= int(BufSize-z.stream.avail_out) {
-			z.offset = 0
-			z.stream.next_out = z.outputBuf
-			z.stream.avail_out = BufSize
-		}
-
-		for !z.inflateEnd && z.offset >= int(BufSize-z.stream.avail_out) {
-			if err = z.inflate(); err != nil {
-				return 0, fmt.Errorf("inflater: %w", err)
-			}
-		}
-
-		if z.offset >= int(BufSize-z.stream.avail_out) {
-			if n == 0 {
-				return 0, io.EOF
-			}
-			return n, nil
-		}
-
-		uncompressed := unsafe.Slice((*byte)(z.outputBuf), int(BufSize-z.stream.avail_out))
-		copied := copy(p[n:], uncompressed[z.offset:])
-		n += copied
-		z.offset += copied
-	}
-	return
+	return z.read(p)
 }
 
+// NewZlibReader returns a reader decompressing the zlib stream r. A stream
+// needing a preset dictionary fails with ErrZlibDict, see NewZlibReaderDict.
 func NewZlibReader(r io.Reader) (io.ReadCloser, error) {
-	var stream C.z_stream
-
-	if ret := C.initStream(&stream); ret != C.Z_OK {
-		return nil, fmt.Errorf("unable to init z_stream: %d", int(ret))
-	}
-
-	zlibInBuf := C.malloc(BufSize)
-	zlibOutBuf := C.malloc(BufSize)
-
-	if zlibInBuf == nil || zlibOutBuf == nil {
-		errMessage := C.errMessage()
-		return nil, fmt.Errorf("unable to malloc buffer memory: %s",
-			internal.UnsafeString((*byte)(unsafe.Pointer(errMessage)), int(C.strlen(errMessage))))
-	}
-
-	br := bufio.NewReader(r)
+	return NewZlibReaderDictFunc(r, nil)
+}
 
-	stream.avail_out = BufSize
-	stream.next_out = (*C.uchar)(zlibOutBuf)
+// NewZlibReaderDict is like NewZlibReader but decompresses a stream needing a
+// preset dictionary with dict, which is ignored if the stream doesn't need one.
+func NewZlibReaderDict(r io.Reader, dict []byte) (io.ReadCloser, error) {
+	return NewZlibReaderDictFunc(r, func(uint32) ([]byte, error) {
+		return dict, nil
+	})
+}
 
+// NewZlibReaderDictFunc is like NewZlibReader but decompresses a stream needing a
+// preset dictionary with the one returned by lookup for its DICTID, the Adler-32
+// of the dictionary.
+func NewZlibReaderDictFunc(r io.Reader, lookup func(dictID uint32) ([]byte, error)) (io.ReadCloser, error) {
 	zl := &zlibReader{
-		inflater: inflater{
-			stream:    &stream,
-			inputBuf:  (*C.uchar)(zlibInBuf),
-			outputBuf: (*C.uchar)(zlibOutBuf),
-			br:        br,
-		},
 		adler32: adler32.New(),
 	}
-	if _, err := zl.readHeader(); err != nil {
+	if err := zl.init(r); err != nil {
+		return nil, err
+	}
+	if _, err := zl.readHeader(lookup); err != nil {
+		err = zl.concatError(0, fmt.Errorf("unable to read zlib header data: %w", err))
 		_ = zl.Close()
-		return nil, fmt.Errorf("unable to read zlib header data: %w", err)
+		return nil, err
 	}
 
 	return zl, nil
 }
 
 type zlibMerger struct {
+	deflateMerger
+	cfg        *joinConfig
 	adler32Sum uint32
-	w          *bufio.Writer
-	zlibInBuf  unsafe.Pointer
-	zlibOutBuf unsafe.Pointer
+
+	wroteHeader bool
+	dict        []byte // the preset dictionary of the first input, until it's spliced
 }
 
-func newZlibMerger(w io.Writer) (_ *zlibMerger, err error) {
-	memInBuf := C.malloc(BufSize)
-	memOutBuf := C.malloc(BufSize)
+func newZlibMerger(w io.Writer, cfg *joinConfig) (*zlibMerger, error) {
+	zm := &zlibMerger{
+		cfg:        cfg,
+		adler32Sum: 1, // adler32 checksum should be initialized to 1.
+	}
+	if err := zm.init(w); err != nil {
+		return nil, err
+	}
+	zm.bitShift = cfg.bitShift
+	// the header is written along with the first input if it may need a
+	// dictionary
+	if cfg.zlibDict == nil {
+		if err := zm.writeHeader(nil); err != nil {
+			_ = zm.Close()
+			return nil, err
+		}
+	}
+	return zm, nil
+}
 
-	defer func() {
+// header writes the header deferred by WithZlibDictionary, with the DICTID of
+// r, the first input if it isn't nil, and returns r rewound. The dictionary is
+// only carried over when nothing is written before the first input.
+func (z *zlibMerger) header(r io.Reader) (io.Reader, error) {
+	if z.wroteHeader {
+		return r, nil
+	}
+	var (
+		dictID uint32
+		fdict  bool
+	)
+	if r != nil && len(z.cfg.prefix) == 0 {
+		var err error
+		r, err = peekHeader(r, func(br *bufio.Reader) {
+			_, dictID, fdict, _ = readZlibHeader(br)
+		})
 		if err != nil {
-			if memInBuf != nil {
-				C.free(memInBuf)
-			}
-			if memOutBuf != nil {
-				C.free(memOutBuf)
-			}
+			return r, fmt.Errorf("unable to read the header of the first input: %w", err)
 		}
-	}()
-
-	if memInBuf == nil || memOutBuf == nil {
-		errMessage := C.errMessage()
-		return nil, fmt.Errorf("unable to malloc memory for inflating: %s",
-			internal.UnsafeString((*byte)(unsafe.Pointer(errMessage)), int(C.strlen(errMessage))))
 	}
-
-	zm := &zlibMerger{
-		zlibInBuf:  memInBuf,
-		zlibOutBuf: memOutBuf,
-		adler32Sum: 1, // adler32 checksum should be initialized to 1.
-		w:          bufio.NewWriter(w),
+	if !fdict {
+		return r, z.writeHeader(nil)
 	}
-
-	if _, err = zm.w.Write(simpleZlibHeader); err != nil {
-		return nil, fmt.Errorf("unable to write zlib header: %w", err)
+	dict, err := zlibDictionary(z.cfg.zlibDict, dictID)
+	if err != nil {
+		return r, newConcatError(0, err)
 	}
-	return zm, nil
+	return r, z.writeHeader(dict)
 }
 
-func (z *zlibMerger) Close() error {
-	if z.zlibInBuf != nil {
-		C.free(z.zlibInBuf)
-		z.zlibInBuf = nil
+// writeHeader writes the zlib header, with the DICTID of dict if it isn't nil.
+func (z *zlibMerger) writeHeader(dict []byte) error {
+	z.wroteHeader = true
+	header := simpleZlibHeader
+	if dict != nil {
+		z.dict = dict
+		header = make([]byte, len(zlibDictHeader)+4)
+		copy(header, zlibDictHeader)
+		binary.BigEndian.PutUint32(header[len(zlibDictHeader):], adler32.Checksum(dict))
 	}
-	if z.zlibOutBuf != nil {
-		C.free(z.zlibOutBuf)
-		z.zlibOutBuf = nil
+	if _, err := z.w.Write(header); err != nil {
+		return fmt.Errorf("unable to write zlib header: %w", err)
 	}
 	return nil
 }
 
-func (z *zlibMerger) concat(r io.Reader, isLastReader bool) (err error) {
-	br := bufio.NewReader(r)
-	if _, err = readZlibHeader(br); err != nil {
+func (z *zlibMerger) concat(ctx context.Context, r io.Reader) (err error) {
+	br := z.open(r)
+	defer func() {
+		if err != nil {
+			err = newConcatError(z.pos(), err)
+		}
+	}()
+
+	_, dictID, fdict, err := readZlibHeader(br)
+	if err != nil {
 		return fmt.Errorf("unable to skip the zlib header: %w", err)
 	}
-
-	var stream C.z_stream
-
-	if ret := C.initStream(&stream); ret != C.Z_OK {
-		return fmt.Errorf("unable to init z_stream: %d", int(ret))
+	// only the first input may use the dictionary carried over to the output
+	dict := z.dict
+	z.dict = nil
+	switch {
+	case !fdict:
+	case z.cfg.zlibDict == nil:
+		return fmt.Errorf("%w: preset dictionary 0x%08x required, see WithZlibDictionary", ErrZlibDict, dictID)
+	case dict == nil:
+		return fmt.Errorf("%w: only the first input may need a preset dictionary", ErrZlibDict)
+	default:
+		z.deflateMerger.dict = dict
 	}
-	defer C.inflateEnd(&stream)
-
-	inputBuf := (*C.uchar)(z.zlibInBuf)
-	outputBuf := (*C.uchar)(z.zlibOutBuf)
 
 	adler32Checker := adler32.New()
-	uncompressedSize64 := int64(0)
-
-	readSize, err := readToBuf(&stream, br, inputBuf)
+	_, err = z.splice(ctx, br, adler32Checker)
 	if err != nil {
 		return err
 	}
 
-	stream.next_out = outputBuf
-	stream.avail_out = BufSize
-
-	lastBlock := (*(*byte)(inputBuf))&1 != 0
-	if lastBlock && !isLastReader {
-		*(*byte)(inputBuf) = *(*byte)(inputBuf) & (^byte(1))
-	}
-
-	for {
-		if stream.avail_in == 0 && stream.avail_out > 0 {
-			if _, err = z.w.Write(unsafe.Slice((*byte)(inputBuf), readSize)); err != nil {
-				return fmt.Errorf("unable to write: %w", err)
-			}
-			if readSize, err = readToBuf(&stream, br, inputBuf); err != nil {
-				return err
-			}
+	if z.cfg.strict {
+		checksumBytes := make([]byte, 4)
+		if _, err = io.ReadFull(br, checksumBytes); err != nil {
+			return fmt.Errorf("unable to read zlib trailer: %w", err)
 		}
-
-		if stream.avail_out < BufSize {
-			_, _ = adler32Checker.Write(unsafe.Slice((*byte)(outputBuf), int(BufSize-stream.avail_out)))
-			stream.next_out = outputBuf
-			stream.avail_out = BufSize
+		adler32Sum := binary.BigEndian.Uint32(checksumBytes)
+		if adler32Checker.Sum32() != adler32Sum {
+			return fmt.Errorf("%w: expect 0x%x, got 0x%x", ErrZlibSum, adler32Checker.Sum32(), adler32Sum)
 		}
+	}
 
-		// dfjoin._Ctype_uint, *dfjoin._Ctype_uchar
-		//fmt.Printf("%T, %T\n", stream.avail_in, stream.next_in)
-		ret := C.inflate(&stream, C.Z_BLOCK)
+	z.in.Checksum = adler32Checker.Sum32()
+	z.combine(z.in)
+	return nil
+}
 
-		if errCode, ok := inflateErrors[int(ret)]; ok {
-			return fmt.Errorf("unable to inflate, error code: %d(%s)", int(ret), errCode)
-		}
+func (z *zlibMerger) combine(in *InputStats) {
+	z.adler32Sum = Adler32Combine(z.adler32Sum, in.Checksum, in.UncompressedBytes)
+}
 
-		uncompressedSize64 += int64(BufSize - stream.avail_out)
+func (z *zlibMerger) checksum(in *InputStats, _, adler32Sum uint32) {
+	in.Checksum = adler32Sum
+}
 
-		if stream.data_type&C.int(128) != 0 {
-			if lastBlock {
-				break
-			}
-			pos := stream.data_type & 7 // 00000111
-			if pos != 0 {
-				pos = 0x100 >> pos
-				preByte := unsafe.Slice((*byte)(inputBuf), readSize)[readSize-int(stream.avail_in)-1]
-				lastBlock = byte(pos)&preByte != 0
-				if lastBlock && !isLastReader {
-					unsafe.Slice((*byte)(inputBuf), readSize)[readSize-int(stream.avail_in)-1] &= ^byte(pos)
-				}
-			} else {
-				if stream.avail_in == 0 {
-					if _, err = z.w.Write(unsafe.Slice((*byte)(inputBuf), readSize)); err != nil {
-						return fmt.Errorf("unable to output: %w", err)
-					}
-
-					if readSize, err = readToBuf(&stream, br, inputBuf); err != nil {
-						return
-					}
-				}
-				lastBlock = (*(*byte)(stream.next_in))&1 != 0
-				if lastBlock && !isLastReader {
-					*(*byte)(stream.next_in) &= ^byte(1)
-				}
-			}
-		}
-	}
+func (z *zlibMerger) format() Format {
+	return FormatZlib
+}
 
-	if stream.avail_out < BufSize {
-		_, _ = adler32Checker.Write(unsafe.Slice((*byte)(outputBuf), int(BufSize-stream.avail_out)))
+func (z *zlibMerger) worker() (merger, error) {
+	wm := &zlibMerger{cfg: z.cfg, adler32Sum: 1}
+	if err := wm.init(io.Discard); err != nil {
+		return nil, err
 	}
+	wm.bitShift = z.bitShift
+	return wm, nil
+}
 
-	pos := stream.data_type & 7
-	if _, err = z.w.Write(unsafe.Slice((*byte)(inputBuf), readSize-int(stream.avail_in)-1)); err != nil {
-		err = fmt.Errorf("unable to output: %w", err)
-		return
+func (z *zlibMerger) finish() error {
+	if err := z.deflateMerger.finish(); err != nil {
+		return err
 	}
-
-	lastByte := unsafe.Slice((*byte)(inputBuf), readSize)[readSize-int(stream.avail_in)-1]
-
-	if pos == 0 || isLastReader {
-		if err = z.w.WriteByte(lastByte); err != nil {
-			err = fmt.Errorf("unable to output last byte: %w", err)
-			return
-		}
-	} else {
-		lastByte &= byte((int(0x100) >> pos) - 1)
-		if pos&1 != 0 {
-			// odd
-			if err = z.w.WriteByte(lastByte); err != nil {
-				err = fmt.Errorf("unable to output last byte: %w", err)
-				return
-			}
-			if pos == 1 {
-				if err = z.w.WriteByte(0); err != nil {
-					err = fmt.Errorf("unable to output last byte: %w", err)
-					return
-				}
-			}
-			if _, err = z.w.Write([]byte{0, 0, 255, 255}); err != nil {
-				err = fmt.Errorf("unable to output empty block: %w", err)
-				return
-			}
-		} else {
-			// even
-			switch pos {
-			case 6:
-				if err = z.w.WriteByte(lastByte | 8); err != nil {
-					err = fmt.Errorf("unable to output last byte: %w", err)
-					return
-				}
-				lastByte = 0
-				fallthrough
-			case 4:
-				if err = z.w.WriteByte(lastByte | 0x20); err != nil {
-					err = fmt.Errorf("unable to output last byte: %w", err)
-					return
-				}
-				lastByte = 0
-				fallthrough
-			case 2:
-				if err = z.w.WriteByte(lastByte | 0x80); err != nil {
-					err = fmt.Errorf("unable to output last byte: %w", err)
-					return
-				}
-				if err = z.w.WriteByte(0); err != nil {
-					err = fmt.Errorf("unable to output last byte: %w", err)
-					return
-				}
-			}
-		}
+	trailer := make([]byte, 4)
+	binary.BigEndian.PutUint32(trailer, z.adler32Sum)
+	if _, err := z.w.Write(trailer); err != nil {
+		return fmt.Errorf("unable to output zlib trailer: %w", err)
 	}
-
-	z.adler32Sum = Adler32Combine(z.adler32Sum, adler32Checker.Sum32(), uncompressedSize64)
-
-	if isLastReader {
-		trailer := make([]byte, 4)
-		binary.BigEndian.PutUint32(trailer, z.adler32Sum)
-		if _, err := z.w.Write(trailer); err != nil {
-			return fmt.Errorf("unable to output zlib trailer: %w", err)
-		}
-		if err = z.w.Flush(); err != nil {
-			return fmt.Errorf("unable to flush write buffer: %w", err)
-		}
+	if err := z.w.Flush(); err != nil {
+		return fmt.Errorf("unable to flush write buffer: %w", err)
 	}
-
 	return nil
 }
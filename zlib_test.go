@@ -6,6 +6,7 @@ import (
 	crand "crypto/rand"
 	"errors"
 	"fmt"
+	"hash/adler32"
 	"io"
 	"math"
 	"math/rand"
@@ -217,3 +218,81 @@ func BenchmarkConcatZlib(b *testing.B) {
 	})
 
 }
+
+func TestZlibDictionary(t *testing.T) {
+	dict := []byte(`{"id":,"name":"","tags":["alpha","beta"],"enabled":true}`)
+	compress := func(p []byte, dict []byte) []byte {
+		out := new(bytes.Buffer)
+		zw, err := zlib.NewWriterLevelDict(out, zlib.BestCompression, dict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = zw.Write(p)
+		_ = zw.Close()
+		return out.Bytes()
+	}
+	msg1 := []byte(`{"id":1,"name":"first","tags":["alpha","beta"],"enabled":true}`)
+	msg2 := []byte(`{"id":2,"name":"second","tags":["beta"],"enabled":false}`)
+	withDict, plain := compress(msg1, dict), compress(msg2, nil)
+	lookup := func(dictID uint32) ([]byte, error) {
+		if dictID != adler32.Checksum(dict) {
+			return nil, fmt.Errorf("unknown dictionary 0x%08x", dictID)
+		}
+		return dict, nil
+	}
+
+	if _, err := NewZlibReader(bytes.NewReader(withDict)); !errors.Is(err, ErrZlibDict) {
+		t.Fatalf("expect ErrZlibDict, got %v", err)
+	}
+	if _, err := NewZlibReaderDict(bytes.NewReader(withDict), []byte("wrong")); !errors.Is(err, ErrZlibDict) {
+		t.Fatalf("expect ErrZlibDict, got %v", err)
+	}
+	for _, zr := range []func() (io.ReadCloser, error){
+		func() (io.ReadCloser, error) { return NewZlibReaderDict(bytes.NewReader(withDict), dict) },
+		func() (io.ReadCloser, error) { return NewZlibReaderDictFunc(bytes.NewReader(withDict), lookup) },
+	} {
+		r, err := zr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg1, data) {
+			t.Fatalf("expect %q, got %q", msg1, data)
+		}
+		_ = r.Close()
+	}
+
+	if err := ConcatZlib(io.Discard, bytes.NewReader(withDict), bytes.NewReader(plain)); !errors.Is(err, ErrZlibDict) {
+		t.Fatalf("expect ErrZlibDict, got %v", err)
+	}
+	err := ConcatZlibWith(io.Discard, []io.Reader{bytes.NewReader(plain), bytes.NewReader(withDict)}, WithZlibDictionary(lookup))
+	if !errors.Is(err, ErrZlibDict) {
+		t.Fatalf("expect ErrZlibDict, got %v", err)
+	}
+
+	want := append(append([]byte(nil), msg1...), msg2...)
+	for _, opts := range [][]Option{
+		{WithZlibDictionary(lookup)},
+		{WithZlibDictionary(lookup), WithParallel(2, 0)},
+	} {
+		joined := new(bytes.Buffer)
+		inputs := []io.Reader{struct{ io.Reader }{bytes.NewReader(withDict)}, bytes.NewReader(plain)}
+		if err = ConcatZlibWith(joined, inputs, opts...); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zlib.NewReaderDict(joined, dict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, data) {
+			t.Fatalf("expect %q, got %q", want, data)
+		}
+	}
+}